@@ -0,0 +1,196 @@
+package querybuilder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var accumulators = map[string]string{
+	"sum":      "$sum",
+	"avg":      "$avg",
+	"min":      "$min",
+	"max":      "$max",
+	"first":    "$first",
+	"last":     "$last",
+	"addToSet": "$addToSet",
+}
+
+func (o Options) IsAggregation() bool {
+	return len(o.Group) > 0 || len(o.Aggregate) > 0
+}
+
+// Pipeline compiles group, aggregate and having parameters, together with the
+// filter, sort and page parameters, into an aggregation pipeline.
+func (qb QueryBuilder) Pipeline(opt Options) (mongo.Pipeline, error) {
+	var (
+		filters bson.D
+		err     error
+	)
+	if len(opt.Filter) > 0 {
+		filters, err = qb.Filter(opt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pipeline, err := qb.aggregationPipeline(opt, filters, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(pipeline, pageStages(opt.Page)...), nil
+}
+
+// aggregationPipeline returns every stage except $skip and $limit, so that
+// callers can count the groups with the same stages. storageName, when set,
+// gives the stored path read for a group or aggregate field; the outputs keep
+// the requested names.
+func (qb QueryBuilder) aggregationPipeline(opt Options, filters bson.D, storageName func(string) string) (mongo.Pipeline, error) {
+	if storageName == nil {
+		storageName = func(name string) string { return name }
+	}
+	var pipeline mongo.Pipeline
+	if len(filters) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filters}})
+	}
+	group := bson.D{{Key: "_id", Value: groupID(opt.Group, storageName)}}
+	project := bson.D{{Key: "_id", Value: 0}}
+	outputs := map[string]bool{}
+	for _, field := range opt.Group {
+		if qb.strictValidation {
+			if _, ok := qb.fieldTypes[storageName(field)]; !ok {
+				return nil, fmt.Errorf("field %s does not exist in collection", field)
+			}
+		}
+		if len(opt.Group) == 1 {
+			project = append(project, bson.E{Key: field, Value: "$_id"})
+		} else {
+			project = append(project, bson.E{Key: field, Value: "$_id." + field})
+		}
+		outputs[field] = true
+	}
+	names := make([]string, 0, len(opt.Aggregate))
+	for name := range opt.Aggregate {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		accumulator, err := qb.accumulator(opt.Aggregate[name], storageName)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, bson.E{Key: name, Value: accumulator})
+		project = append(project, bson.E{Key: name, Value: 1})
+		outputs[name] = true
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$group", Value: group}}, bson.D{{Key: "$project", Value: project}})
+	if len(opt.Having) > 0 {
+		having, err := havingFilter(opt.Having, outputs)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: having}})
+	}
+	if len(opt.Sort) > 0 || len(opt.Page) > 0 {
+		sortStage := bson.D{}
+		sorted := map[string]bool{}
+		for _, field := range opt.Sort {
+			val := 1
+			if strings.HasPrefix(field, "-") {
+				val = -1
+			}
+			field = strings.TrimLeft(field, "+-")
+			if !outputs[field] {
+				return nil, fmt.Errorf("field %s is not part of the aggregation", field)
+			}
+			sortStage = append(sortStage, bson.E{Key: field, Value: val})
+			sorted[field] = true
+		}
+		// The group fields identify a group, so they break ties between
+		// pages like _id does for a find.
+		for _, field := range opt.Group {
+			if !sorted[field] {
+				sortStage = append(sortStage, bson.E{Key: field, Value: 1})
+			}
+		}
+		if len(sortStage) > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sortStage}})
+		}
+	}
+	return pipeline, nil
+}
+
+func groupID(fields []string, storageName func(string) string) interface{} {
+	switch len(fields) {
+	case 0:
+		return nil
+	case 1:
+		return "$" + storageName(fields[0])
+	default:
+		id := bson.D{}
+		for _, field := range fields {
+			id = append(id, bson.E{Key: field, Value: "$" + storageName(field)})
+		}
+		return id
+	}
+}
+
+// accumulator parses "count" and "op:field" expressions such as "sum:amount".
+func (qb QueryBuilder) accumulator(expr string, storageName func(string) string) (bson.D, error) {
+	if expr == "count" {
+		return bson.D{{Key: "$sum", Value: 1}}, nil
+	}
+	parts := strings.SplitN(expr, ":", 2)
+	op, ok := accumulators[parts[0]]
+	if !ok || len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid aggregate %s", expr)
+	}
+	if qb.strictValidation {
+		if _, ok := qb.fieldTypes[storageName(parts[1])]; !ok {
+			return nil, fmt.Errorf("field %s does not exist in collection", parts[1])
+		}
+	}
+	return bson.D{{Key: op, Value: "$" + storageName(parts[1])}}, nil
+}
+
+func havingFilter(having map[string]interface{}, outputs map[string]bool) (bson.D, error) {
+	filters := bson.D{}
+	for _, term := range filterTerms(having) {
+		if !outputs[term.Field] {
+			return nil, fmt.Errorf("field %s is not part of the aggregation", term.Field)
+		}
+		op := term.Operator
+		if op == "$like" {
+			return nil, fmt.Errorf("operator %s is not supported in having", op)
+		}
+		var values []interface{}
+		for _, value := range term.Values {
+			values = append(values, validateValue(trimOperatorPrefix(fmt.Sprint(value))))
+		}
+		if op == "$in" {
+			filters = append(filters, bson.E{Key: term.Field, Value: bson.D{{Key: op, Value: values}}})
+			continue
+		}
+		filters = append(filters, bson.E{Key: term.Field, Value: bson.D{{Key: op, Value: values[0]}}})
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Key < filters[j].Key })
+	return filters, nil
+}
+
+// pageStages applies the page parameters like a find: skip wins over offset,
+// and size and page over limit.
+func pageStages(pagination map[string]int) []bson.D {
+	opts := options.Find()
+	QueryBuilder{}.setPaginationOptions(pagination, opts)
+	var stages []bson.D
+	if opts.Skip != nil {
+		stages = append(stages, bson.D{{Key: "$skip", Value: *opts.Skip}})
+	}
+	if opts.Limit != nil {
+		stages = append(stages, bson.D{{Key: "$limit", Value: *opts.Limit}})
+	}
+	return stages
+}
@@ -0,0 +1,168 @@
+package querybuilder
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPageStages(t *testing.T) {
+	tests := []struct {
+		name string
+		page map[string]int
+		want []bson.D
+	}{
+		{name: "none"},
+		{
+			name: "limit",
+			page: map[string]int{"limit": 5},
+			want: []bson.D{{{Key: "$limit", Value: int64(5)}}},
+		},
+		{
+			name: "offset and skip",
+			page: map[string]int{"limit": 5, "offset": 10, "skip": 20},
+			want: []bson.D{{{Key: "$skip", Value: int64(20)}}, {{Key: "$limit", Value: int64(5)}}},
+		},
+		{
+			name: "page and size",
+			page: map[string]int{"page": 2, "size": 10},
+			want: []bson.D{{{Key: "$skip", Value: int64(20)}}, {{Key: "$limit", Value: int64(10)}}},
+		},
+		{
+			name: "size wins over limit",
+			page: map[string]int{"limit": 5, "offset": 3, "page": 1, "size": 10},
+			want: []bson.D{{{Key: "$skip", Value: int64(10)}}, {{Key: "$limit", Value: int64(10)}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pageStages(tt.page); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaValidateAggregation(t *testing.T) {
+	schema := &Schema{Fields: map[string]Field{
+		"brand":  {Type: "string", Selectable: true},
+		"price":  {Type: "number", Selectable: true},
+		"cost":   {Type: "number"},
+		"secret": {Type: "string", Selectable: true, Hidden: true},
+	}}
+	tests := []struct {
+		name    string
+		opt     Options
+		wantErr bool
+	}{
+		{name: "group", opt: Options{Group: []string{"brand"}}},
+		{name: "aggregate", opt: Options{Aggregate: map[string]string{"total": "sum:price", "n": "count"}}},
+		{name: "group not selectable", opt: Options{Group: []string{"cost"}}, wantErr: true},
+		{name: "group hidden", opt: Options{Group: []string{"secret"}}, wantErr: true},
+		{name: "group unknown", opt: Options{Group: []string{"other"}}, wantErr: true},
+		{name: "aggregate not selectable", opt: Options{Aggregate: map[string]string{"total": "sum:cost"}}, wantErr: true},
+		{name: "aggregate hidden", opt: Options{Aggregate: map[string]string{"total": "max:secret"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate(context.Background(), tt.opt)
+			var policyErr *PolicyError
+			if tt.wantErr != errors.As(err, &policyErr) {
+				t.Errorf("got %v, want a policy error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAggregationPipelineAliases(t *testing.T) {
+	schema := &Schema{
+		Fields: map[string]Field{
+			"brand": {Type: "string", Selectable: true},
+			"price": {Type: "number", Selectable: true},
+		},
+		Aliases: map[string]string{"brand": "meta.brand", "price": "pricing.amount"},
+	}
+	c := builderConfig{schema: schema}
+	tests := []struct {
+		name string
+		opt  Options
+		want bson.D
+	}{
+		{
+			name: "single group",
+			opt:  Options{Group: []string{"brand"}, Aggregate: map[string]string{"total": "sum:price"}},
+			want: bson.D{
+				{Key: "_id", Value: "$meta.brand"},
+				{Key: "total", Value: bson.D{{Key: "$sum", Value: "$pricing.amount"}}},
+			},
+		},
+		{
+			name: "compound group",
+			opt:  Options{Group: []string{"brand", "price"}, Aggregate: map[string]string{"n": "count"}},
+			want: bson.D{
+				{Key: "_id", Value: bson.D{{Key: "brand", Value: "$meta.brand"}, {Key: "price", Value: "$pricing.amount"}}},
+				{Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := c.queryBuilder().aggregationPipeline(tt.opt, nil, c.storageName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pipeline[0][0].Value; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got $group %v, want %v", got, tt.want)
+			}
+			for _, field := range pipeline[1][0].Value.(bson.D)[1:] {
+				if _, ok := tt.opt.Aggregate[field.Key]; !ok && !contains(tt.opt.Group, field.Key, false) {
+					t.Errorf("output %s is not a requested name", field.Key)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregationPipelineSort(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Options
+		want bson.D
+	}{
+		{name: "unsorted", opt: Options{Group: []string{"brand"}}},
+		{
+			name: "paged",
+			opt:  Options{Group: []string{"brand"}, Page: map[string]int{"limit": 5}},
+			want: bson.D{{Key: "brand", Value: 1}},
+		},
+		{
+			name: "tie-breaker",
+			opt:  Options{Group: []string{"brand", "color"}, Aggregate: map[string]string{"n": "count"}, Sort: []string{"-n", "color"}},
+			want: bson.D{{Key: "n", Value: -1}, {Key: "color", Value: 1}, {Key: "brand", Value: 1}},
+		},
+		{
+			name: "without group",
+			opt:  Options{Aggregate: map[string]string{"n": "count"}, Page: map[string]int{"limit": 5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := NewQueryBuilder(false).aggregationPipeline(tt.opt, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bson.D
+			for _, stage := range pipeline {
+				if stage[0].Key == "$sort" {
+					got = stage[0].Value.(bson.D)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got $sort %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package querybuilder
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// aliasPatterns caches the pattern matching each alias target in error
+// messages.
+var aliasPatterns sync.Map
+
+type aliasError struct {
+	err error
+	msg string
+}
+
+func (e *aliasError) Error() string {
+	return e.msg
+}
+
+func (e *aliasError) Unwrap() error {
+	return e.err
+}
+
+// StorageName returns the stored path of an API field name. A name below an
+// aliased path keeps its remaining segments, so with customer mapped to cust,
+// customer.name is stored as cust.name.
+func (s *Schema) StorageName(name string) string {
+	if storage, ok := s.Aliases[name]; ok {
+		return storage
+	}
+	for i := strings.LastIndex(name, "."); i > 0; i = strings.LastIndex(name[:i], ".") {
+		if storage, ok := s.Aliases[name[:i]]; ok {
+			return storage + name[i:]
+		}
+	}
+	return name
+}
+
+// apiName is the reverse of StorageName. ok is false when storage is not the
+// target of an alias.
+func (s *Schema) apiName(storage string) (string, bool) {
+	for name, target := range s.Aliases {
+		if target == storage {
+			return name, true
+		}
+	}
+	for name, target := range s.Aliases {
+		if strings.HasPrefix(storage, target+".") {
+			return name + storage[len(target):], true
+		}
+	}
+	return storage, false
+}
+
+// storageOptions returns a copy of opt with the filter, sort and fields
+// rewritten to stored paths.
+func (s *Schema) storageOptions(opt Options) Options {
+	if len(s.Aliases) == 0 {
+		return opt
+	}
+	opt.Filter = s.storageFilter(opt.Filter)
+	opt.Sort = s.storageNames(opt.Sort)
+	opt.Fields = s.storageNames(opt.Fields)
+	return opt
+}
+
+func (s *Schema) storageNames(names []string) []string {
+	if names == nil {
+		return nil
+	}
+	result := make([]string, len(names))
+	for i, name := range names {
+		field := strings.TrimLeft(name, "+-")
+		result[i] = name[:len(name)-len(field)] + s.StorageName(field)
+	}
+	return result
+}
+
+// storageFilter rewrites top level keys such as customerName][$like, and the
+// keys of $or branches. An aliased prefix of a bracketed key becomes one
+// dotted segment, so it stays an embedded path rather than an array element.
+func (s *Schema) storageFilter(filter map[string]interface{}) map[string]interface{} {
+	if filter == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(filter))
+	for key, value := range filter {
+		if key == "$or" {
+			result[key] = s.storageBranches(value)
+			continue
+		}
+		path := strings.Split(key, "][")
+		op := ""
+		if last := path[len(path)-1]; len(path) > 1 && strings.HasPrefix(last, "$") {
+			op = "][" + last
+			path = path[:len(path)-1]
+		}
+		result[s.storageKey(path)+op] = value
+	}
+	return result
+}
+
+func (s *Schema) storageKey(path []string) string {
+	for i := len(path); i > 0; i-- {
+		if storage, ok := s.Aliases[strings.Join(path[:i], ".")]; ok {
+			return strings.Join(append([]string{storage}, path[i:]...), "][")
+		}
+	}
+	return strings.Join(append([]string{s.StorageName(path[0])}, path[1:]...), "][")
+}
+
+func (s *Schema) storageBranches(value interface{}) interface{} {
+	list, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	branches := make([]interface{}, len(list))
+	for i, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			branches[i] = s.storageBranch("", m)
+			continue
+		}
+		branches[i] = item
+	}
+	return branches
+}
+
+func (s *Schema) storageBranch(prefix string, m map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key, value := range m {
+		if key == "$or" {
+			result[key] = s.storageBranches(value)
+			continue
+		}
+		field, name := key, s.StorageName(key)
+		if prefix != "" {
+			field = prefix + "." + key
+			name = strings.TrimPrefix(s.StorageName(field), s.StorageName(prefix)+".")
+		}
+		if sub, ok := value.(map[string]interface{}); ok && !hasOperatorKey(sub) {
+			result[name] = s.storageBranch(field, sub)
+			continue
+		}
+		result[name] = value
+	}
+	return result
+}
+
+func hasOperatorKey(m map[string]interface{}) bool {
+	for key := range m {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// apiError replaces stored paths in the message of err with their API names,
+// so errors raised after translation do not expose the storage layout.
+func (s *Schema) apiError(err error) error {
+	if err == nil || len(s.Aliases) == 0 {
+		return err
+	}
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		if name, ok := s.apiName(policyErr.Field); ok {
+			translated := *policyErr
+			translated.Field = name
+			return &translated
+		}
+		return err
+	}
+	targets := make([]string, 0, len(s.Aliases))
+	for _, target := range s.Aliases {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool { return len(targets[i]) > len(targets[j]) })
+	msg := err.Error()
+	for _, target := range targets {
+		name, _ := s.apiName(target)
+		msg = aliasPattern(target).ReplaceAllStringFunc(msg, func(match string) string {
+			return strings.Replace(match, target, name, 1)
+		})
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &aliasError{err: err, msg: msg}
+}
+
+func aliasPattern(target string) *regexp.Regexp {
+	if re, ok := aliasPatterns.Load(target); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(`(^|[^\w.$])` + regexp.QuoteMeta(target) + `(\.[\w.]+)?($|[^\w.])`)
+	aliasPatterns.Store(target, re)
+	return re
+}
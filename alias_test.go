@@ -0,0 +1,33 @@
+package querybuilder
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSchemaAPIError(t *testing.T) {
+	schema := &Schema{Aliases: map[string]string{"id": "_id", "customer": "cust"}}
+	cause := errors.New("cause")
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{"stored path", "field _id is invalid", "field id is invalid"},
+		{"sub-path", "field cust.name does not exist", "field customer.name does not exist"},
+		{"longer name", "field custom is invalid", "field custom is invalid"},
+		{"operator", "$cust is invalid", "$cust is invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.apiError(fmt.Errorf("%s: %w", tt.msg, cause))
+			if got := err.Error(); got != tt.want+": cause" {
+				t.Errorf("got %q, want %q", got, tt.want+": cause")
+			}
+			if !errors.Is(err, cause) {
+				t.Error("the translated error does not wrap the original")
+			}
+		})
+	}
+}
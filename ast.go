@@ -0,0 +1,362 @@
+package querybuilder
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Operator string
+
+const (
+	Eq   Operator = "$eq"
+	Ne   Operator = "$ne"
+	Gt   Operator = "$gt"
+	Gte  Operator = "$gte"
+	Lt   Operator = "$lt"
+	Lte  Operator = "$lte"
+	Size Operator = "$size"
+
+	// Like and Nin are accepted by OptionsBuilder.Where; ParseFilter expresses
+	// them as Match and Not{In}.
+	Like Operator = "$like"
+	Nin  Operator = "$nin"
+)
+
+// Expr is a node of the backend agnostic filter tree produced by ParseFilter.
+type Expr interface {
+	expr()
+}
+
+type And struct {
+	Exprs []Expr
+}
+
+type Or struct {
+	Exprs []Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+type Compare struct {
+	Field string
+	Op    Operator
+	Value interface{}
+}
+
+type In struct {
+	Field  string
+	Values []interface{}
+}
+
+type Exists struct {
+	Field  string
+	Exists bool
+}
+
+type Match struct {
+	Field   string
+	Pattern string
+}
+
+// ElemMatch matches documents where one element of the array Field satisfies
+// Expr. The fields of Expr are relative to the element.
+type ElemMatch struct {
+	Field string
+	Expr  Expr
+}
+
+func (And) expr()       {}
+func (Or) expr()        {}
+func (Not) expr()       {}
+func (Compare) expr()   {}
+func (In) expr()        {}
+func (Exists) expr()    {}
+func (Match) expr()     {}
+func (ElemMatch) expr() {}
+
+// Compiler turns a filter tree into the query representation of a store.
+type Compiler interface {
+	CompileExpr(expr Expr) (interface{}, error)
+}
+
+func (o Options) FilterExpr() (Expr, error) {
+	return ParseFilter(o.Filter)
+}
+
+// ParseFilter converts the filter of parsed Options into an expression tree.
+// Keys are visited in sorted order so the same filter always yields the same
+// tree.
+//
+// A single value may start with a comparison prefix and the value null stands
+// for null, see the package documentation. The checkFilter compiler this
+// replaces compared both literally, as filter[field][$eq]=value still does
+// for prefixed values.
+func ParseFilter(filter map[string]interface{}) (Expr, error) {
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	and := And{}
+	for _, key := range keys {
+		value := filter[key]
+		if key == "$or" {
+			or, err := parseOr(value)
+			if err != nil {
+				return nil, err
+			}
+			and.Exprs = append(and.Exprs, or)
+			continue
+		}
+		path := strings.Split(key, "][")
+		op := ""
+		if last := path[len(path)-1]; len(path) > 1 && strings.HasPrefix(last, "$") {
+			op, path = last, path[:len(path)-1]
+		}
+		expr, err := parseTerm(path, op, value)
+		if err != nil {
+			return nil, err
+		}
+		and.Exprs = append(and.Exprs, expr)
+	}
+	if len(and.Exprs) == 1 {
+		return and.Exprs[0], nil
+	}
+	return and, nil
+}
+
+func parseOr(value interface{}) (Expr, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid format for $or")
+	}
+	or := Or{}
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		expr, err := ParseFilter(m)
+		if err != nil {
+			return nil, err
+		}
+		or.Exprs = append(or.Exprs, expr)
+	}
+	return or, nil
+}
+
+// parseTerm parses the value of a bracketed filter key. As in the original
+// checkFilter, the segments after the first address the elements of an array:
+// tags][name becomes an ElemMatch on tags, and a null among several element
+// values also accepts an empty array.
+func parseTerm(path []string, op string, value interface{}) (Expr, error) {
+	if len(path) == 1 {
+		if op != "" {
+			return parseOperator(path[0], op, value)
+		}
+		return parseValue(path[0], value)
+	}
+	field := strings.Join(path[1:], ".")
+	if op != "" {
+		expr, err := parseOperator(field, op, value)
+		if err != nil {
+			return nil, err
+		}
+		return ElemMatch{Field: path[0], Expr: expr}, nil
+	}
+	values := termValues(value)
+	var present []interface{}
+	for _, v := range values {
+		if v != "null" {
+			present = append(present, v)
+		}
+	}
+	if len(values) < 2 || len(present) == len(values) {
+		expr, err := parseValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		return ElemMatch{Field: path[0], Expr: expr}, nil
+	}
+	expr, err := parseValue(field, present)
+	if err != nil {
+		return nil, err
+	}
+	return Or{Exprs: []Expr{
+		ElemMatch{Field: path[0], Expr: expr},
+		Compare{Field: path[0], Op: Size, Value: 0},
+	}}, nil
+}
+
+func parseValue(field string, value interface{}) (Expr, error) {
+	if m, ok := value.(map[string]interface{}); ok && !hasOperatorKey(m) {
+		expr, err := ParseFilter(m)
+		if err != nil {
+			return nil, err
+		}
+		return ElemMatch{Field: field, Expr: expr}, nil
+	}
+	if m, ok := value.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		and := And{}
+		for _, key := range keys {
+			var (
+				expr Expr
+				err  error
+			)
+			if strings.HasPrefix(key, "$") {
+				expr, err = parseOperator(field, key, m[key])
+			} else {
+				expr, err = parseValue(field+"."+key, m[key])
+			}
+			if err != nil {
+				return nil, err
+			}
+			and.Exprs = append(and.Exprs, expr)
+		}
+		if len(and.Exprs) == 1 {
+			return and.Exprs[0], nil
+		}
+		return and, nil
+	}
+	values := termValues(value)
+	if len(values) > 1 {
+		return In{Field: field, Values: nullValues(values)}, nil
+	}
+	if len(values) == 0 {
+		return In{Field: field}, nil
+	}
+	str, ok := values[0].(string)
+	if !ok {
+		return Compare{Field: field, Op: Eq, Value: values[0]}, nil
+	}
+	switch prefixOperator(str) {
+	case "$like":
+		return Match{Field: field, Pattern: trimOperatorPrefix(str)}, nil
+	case "$eq":
+		return Compare{Field: field, Op: Eq, Value: nullValue(str)}, nil
+	case "$not":
+		return Compare{Field: field, Op: Ne, Value: nullValue(trimOperatorPrefix(str))}, nil
+	default:
+		return Compare{Field: field, Op: Operator(prefixOperator(str)), Value: nullValue(trimOperatorPrefix(str))}, nil
+	}
+}
+
+func parseOperator(field string, op string, value interface{}) (Expr, error) {
+	values := nullValues(termValues(value))
+	first := func() interface{} {
+		if len(values) == 0 {
+			return nil
+		}
+		return values[0]
+	}
+	switch op {
+	case "$in":
+		return In{Field: field, Values: values}, nil
+	case "$nin":
+		return Not{Expr: In{Field: field, Values: values}}, nil
+	case "$like", "$regex":
+		return Match{Field: field, Pattern: fmt.Sprint(first())}, nil
+	case "$exists":
+		exists, err := strconv.ParseBool(fmt.Sprint(first()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for $exists")
+		}
+		return Exists{Field: field, Exists: exists}, nil
+	case "$not":
+		return Not{Expr: Compare{Field: field, Op: Eq, Value: first()}}, nil
+	case "$size":
+		size, err := strconv.Atoi(fmt.Sprint(first()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for $size")
+		}
+		return Compare{Field: field, Op: Size, Value: size}, nil
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		if len(values) > 1 && op == "$eq" {
+			return In{Field: field, Values: values}, nil
+		}
+		return Compare{Field: field, Op: Operator(op), Value: first()}, nil
+	case "$elemMatch":
+		if m, ok := value.(map[string]interface{}); ok && !hasOperatorKey(m) {
+			expr, err := ParseFilter(m)
+			if err != nil {
+				return nil, err
+			}
+			return ElemMatch{Field: field, Expr: expr}, nil
+		}
+		if m, ok := value.(map[string]interface{}); ok {
+			return parseValue(field, m)
+		}
+		return nil, fmt.Errorf("invalid format for %s", op)
+	default:
+		return nil, fmt.Errorf("operator %s is not supported", op)
+	}
+}
+
+func nullValue(value interface{}) interface{} {
+	if value == "null" {
+		return nil
+	}
+	return value
+}
+
+func nullValues(values []interface{}) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = nullValue(value)
+	}
+	return result
+}
+
+// prefixFields returns expr with prefix prepended to every field name. Stores
+// without arrays of documents use it to flatten an ElemMatch into plain
+// dotted paths.
+func prefixFields(prefix string, expr Expr) Expr {
+	name := func(field string) string {
+		if field == "" {
+			return prefix
+		}
+		return prefix + "." + field
+	}
+	switch e := expr.(type) {
+	case And:
+		exprs := make([]Expr, len(e.Exprs))
+		for i, child := range e.Exprs {
+			exprs[i] = prefixFields(prefix, child)
+		}
+		return And{Exprs: exprs}
+	case Or:
+		exprs := make([]Expr, len(e.Exprs))
+		for i, child := range e.Exprs {
+			exprs[i] = prefixFields(prefix, child)
+		}
+		return Or{Exprs: exprs}
+	case Not:
+		return Not{Expr: prefixFields(prefix, e.Expr)}
+	case Compare:
+		e.Field = name(e.Field)
+		return e
+	case In:
+		e.Field = name(e.Field)
+		return e
+	case Exists:
+		e.Field = name(e.Field)
+		return e
+	case Match:
+		e.Field = name(e.Field)
+		return e
+	case ElemMatch:
+		e.Field = name(e.Field)
+		return e
+	default:
+		return expr
+	}
+}
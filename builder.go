@@ -0,0 +1,291 @@
+package querybuilder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInvalidQuery = errors.New("invalid query string")
+
+type builderConfig struct {
+	hooks          []Hook
+	idCodec        IDCodec
+	limits         Limits
+	schema         *Schema
+	scope          *Scope
+	excludeDeleted bool
+}
+
+func (c *builderConfig) SetIDCodec(codec IDCodec) {
+	c.idCodec = codec
+}
+
+func (c *builderConfig) SetLimits(limits Limits) {
+	c.limits = limits
+}
+
+func (c *builderConfig) SetSchema(schema *Schema) {
+	c.schema = schema
+}
+
+func (c *builderConfig) SetScope(scope Scope) {
+	c.scope = &scope
+}
+
+// SetExcludeDeleted hides the documents that DeleteOne marked with deletedAt
+// from every query of the builder.
+func (c *builderConfig) SetExcludeDeleted(exclude bool) {
+	c.excludeDeleted = exclude
+}
+
+func (c *builderConfig) codec() IDCodec {
+	if c.idCodec == nil {
+		return ObjectIDCodec{}
+	}
+	return c.idCodec
+}
+
+func (c *builderConfig) queryBuilder() *QueryBuilder {
+	qb := NewQueryBuilder(true)
+	qb.SetIDCodec(c.codec())
+	if c.schema != nil {
+		qb.fieldTypes = c.schema.fieldTypes()
+	}
+	return qb
+}
+
+func (c *builderConfig) findOptions(ctx context.Context, opt Options) (*options.FindOptions, error) {
+	opt.Sort = c.sortKeys(ctx, opt)
+	opts, err := c.queryBuilder().FindOptions(c.storageOptions(opt))
+	if err != nil {
+		return nil, c.apiError(err)
+	}
+	if c.schema != nil {
+		if prj := c.schema.Projection(ctx, opt.Fields); prj != nil {
+			opts.SetProjection(prj)
+		}
+	}
+	return opts, nil
+}
+
+// sortKeys returns the requested sort, or the schema default, followed by an
+// _id tie-breaker. Without it skip and limit paging can return a document on
+// two pages when keys tie. A unique key makes the tie-breaker unnecessary, and
+// a query without sort is only ordered by _id when it is paged.
+func (c *builderConfig) sortKeys(ctx context.Context, opt Options) []string {
+	keys := opt.Sort
+	if len(keys) == 0 && c.schema != nil {
+		keys = c.schema.DefaultSort
+	}
+	if len(keys) == 0 {
+		if len(opt.Page) == 0 {
+			return nil
+		}
+		return []string{"_id"}
+	}
+	var fields map[string]Field
+	if c.schema != nil {
+		fields = c.schema.fields(ctx)
+	}
+	for _, key := range keys {
+		name := strings.TrimLeft(key, "+-")
+		if c.storageName(name) == "_id" || fields[name].Unique {
+			return keys
+		}
+	}
+	return append(keys[:len(keys):len(keys)], "_id")
+}
+
+// storageOptions translates API names to stored paths when the schema has
+// aliases. The untranslated options are kept for links and metadata.
+func (c *builderConfig) storageOptions(opt Options) Options {
+	if c.schema == nil {
+		return opt
+	}
+	return c.schema.storageOptions(opt)
+}
+
+func (c *builderConfig) storageName(name string) string {
+	if c.schema == nil {
+		return name
+	}
+	return c.schema.StorageName(name)
+}
+
+func (c *builderConfig) apiError(err error) error {
+	if c.schema == nil {
+		return err
+	}
+	return c.schema.apiError(err)
+}
+
+func (c *builderConfig) scopeFilter(ctx context.Context, filters bson.D) (bson.D, error) {
+	if c.excludeDeleted {
+		notDeleted := bson.D{{Key: "deletedAt", Value: nil}}
+		if len(filters) == 0 {
+			filters = notDeleted
+		} else {
+			filters = bson.D{{Key: "$and", Value: bson.A{filters, notDeleted}}}
+		}
+	}
+	if c.scope == nil {
+		return filters, nil
+	}
+	return c.scope.filter(ctx, filters)
+}
+
+// findOne passes hooks the find options of the single document lookup, so
+// that they can change its projection or sort.
+func (c *builderConfig) findOne(ctx context.Context, collection Collection, opt Options, filter bson.D) (*mongo.SingleResult, error) {
+	opts, err := c.findOptions(ctx, Options{Fields: opt.Fields})
+	if err != nil {
+		return nil, err
+	}
+	opts.SetLimit(1)
+	if err := c.beforeExecute(ctx, &filter, opts); err != nil {
+		return nil, err
+	}
+	var result *mongo.SingleResult
+	if !c.needsPipeline(ctx, opt) {
+		one := options.FindOne()
+		one.Projection, one.Sort, one.Skip = opts.Projection, opts.Sort, opts.Skip
+		result = collection.FindOne(ctx, filter, one)
+	} else {
+		result, err = c.aggregateOne(ctx, collection, opt, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := c.afterExecute(ctx, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// aggregateOne runs a single document lookup as an aggregation, for includes
+// and filters on virtual fields.
+func (c *builderConfig) aggregateOne(ctx context.Context, collection Collection, opt Options, filter bson.D, opts *options.FindOptions) (*mongo.SingleResult, error) {
+	pipeline, err := c.pipeline(ctx, Options{Filter: opt.Filter, Fields: opt.Fields, Include: opt.Include, FieldSets: opt.FieldSets}, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil), nil
+	}
+	return mongo.NewSingleResultFromDocument(cursor.Current, nil, nil), nil
+}
+
+func (c *builderConfig) idFilter(ctx context.Context, id string) (bson.D, error) {
+	key, err := c.codec().Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidID, id)
+	}
+	return c.scopeFilter(ctx, bson.D{{Key: "_id", Value: key}})
+}
+
+// Parse parses payload within the builder limits. The options can be changed
+// before they are passed to a WithOptions method, which validates them
+// against the schema and applies the scope.
+func (c *builderConfig) Parse(payload string) (Options, error) {
+	return c.ParseContext(context.TODO(), payload)
+}
+
+func (c *builderConfig) ParseContext(ctx context.Context, payload string) (Options, error) {
+	payload, err := c.beforeParse(ctx, payload)
+	if err != nil {
+		return Options{}, err
+	}
+	opt, err := FromQueryStringWithLimits(payload, c.limits)
+	if err != nil {
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			return opt, err
+		}
+		return opt, ErrInvalidQuery
+	}
+	return opt, nil
+}
+
+// prepare checks options that were parsed or built in code against the limits
+// and schema, and compiles their filter within the scope.
+func (c *builderConfig) prepare(ctx context.Context, opt *Options) (bson.D, error) {
+	if err := c.afterParse(ctx, opt); err != nil {
+		return nil, err
+	}
+	if err := c.validate(ctx, opt); err != nil {
+		return nil, err
+	}
+	filters := bson.D{}
+	if len(opt.Filter) > 0 {
+		var err error
+		filters, err = c.queryBuilder().Filter(c.storageOptions(*opt))
+		if err != nil {
+			return nil, c.apiError(err)
+		}
+	}
+	return c.scopeFilter(ctx, filters)
+}
+
+// validate checks opt against the limits and, after selecting the fields of
+// the schema type from the field sets, against the schema.
+func (c *builderConfig) validate(ctx context.Context, opt *Options) error {
+	if err := c.limits.check(opt); err != nil {
+		return err
+	}
+	if c.schema == nil {
+		return nil
+	}
+	if fields, ok := opt.FieldSets[c.schema.Type]; ok && c.schema.Type != "" {
+		opt.Fields = fields
+	}
+	return c.schema.Validate(ctx, *opt)
+}
+
+// needsPipeline reports whether opt runs as an aggregation rather than a find.
+func (c *builderConfig) needsPipeline(ctx context.Context, opt Options) bool {
+	return len(opt.Include) > 0 || c.virtualStages(ctx, opt) != nil
+}
+
+// pipeline expresses a find as an aggregation, for queries that need stages
+// a find cannot run.
+func (c *builderConfig) pipeline(ctx context.Context, opt Options, filters bson.D, opts *options.FindOptions) (mongo.Pipeline, error) {
+	pipeline := append(mongo.Pipeline{}, c.virtualStages(ctx, opt)...)
+	pipeline = append(pipeline, bson.D{{Key: "$match", Value: filters}})
+	if opts.Sort != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: opts.Sort}})
+	}
+	if opts.Skip != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: *opts.Skip}})
+	}
+	if opts.Limit != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: *opts.Limit}})
+	}
+	if len(opt.Include) > 0 {
+		if c.schema == nil {
+			return nil, errIncludeWithoutSchema
+		}
+		stages, err := c.schema.lookupStages(ctx, newIncludeTree(opt.Include), opt.FieldSets, c.scopeFilter)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, stages...)
+	}
+	if prj, ok := opts.Projection.(map[string]int); ok && len(prj) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: includeProjection(prj, opt.Include)}})
+	}
+	return append(pipeline, c.virtualUnset(ctx, opt)...), nil
+}
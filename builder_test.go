@@ -0,0 +1,69 @@
+package querybuilder
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBuilderSortKeys(t *testing.T) {
+	schema := &Schema{
+		Fields: map[string]Field{
+			"name":  {Type: "string", Sortable: true},
+			"email": {Type: "string", Sortable: true, Unique: true},
+		},
+		DefaultSort: []string{"-name"},
+	}
+	page := map[string]int{"page": 1, "size": 10}
+	tests := []struct {
+		name   string
+		schema *Schema
+		opt    Options
+		want   []string
+	}{
+		{name: "no sort", want: nil},
+		{name: "paged without sort", opt: Options{Page: page}, want: []string{"_id"}},
+		{name: "requested", opt: Options{Sort: []string{"name"}}, want: []string{"name", "_id"}},
+		{name: "default", schema: schema, want: []string{"-name", "_id"}},
+		{name: "unique", schema: schema, opt: Options{Sort: []string{"email"}}, want: []string{"email"}},
+		{name: "already by _id", opt: Options{Sort: []string{"-_id"}, Page: page}, want: []string{"-_id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := builderConfig{schema: tt.schema}
+			if got := c.sortKeys(context.Background(), tt.opt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuilderVirtualDefaultSort(t *testing.T) {
+	fullName := bson.D{{Key: "$concat", Value: bson.A{"$first", " ", "$last"}}}
+	schema := &Schema{
+		Fields: map[string]Field{
+			"fullName": {Type: "string", Sortable: true, Expression: fullName},
+			"first":    {Type: "string", Sortable: true},
+		},
+		DefaultSort: []string{"fullName"},
+	}
+	tests := []struct {
+		name string
+		opt  Options
+		want []string
+	}{
+		{name: "default sort", want: []string{"fullName"}},
+		{name: "requested sort", opt: Options{Sort: []string{"first"}}, want: []string{}},
+		{name: "aggregation", opt: Options{Group: []string{"first"}}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := builderConfig{schema: schema}
+			if got := c.virtualFields(context.Background(), tt.opt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package querybuilder
+
+import (
+	"context"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type DistinctOptions struct {
+	Limit  int
+	Prefix string
+	Counts bool
+}
+
+type DistinctValue struct {
+	Value interface{} `json:"value" bson:"_id"`
+	Count int64       `json:"count,omitempty" bson:"count"`
+}
+
+func (c *ReadBuilder) Distinct(field string, payload string, opts ...DistinctOptions) ([]DistinctValue, error) {
+	return c.DistinctContext(context.TODO(), field, payload, opts...)
+}
+
+// DistinctContext returns the distinct values of field among the documents
+// matching payload. A limit, prefix or counts switch to an aggregation.
+func (c *ReadBuilder) DistinctContext(ctx context.Context, field string, payload string, opts ...DistinctOptions) ([]DistinctValue, error) {
+	query, err := c.ParseContext(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	return c.DistinctWithOptions(ctx, field, query, opts...)
+}
+
+func (c *ReadBuilder) DistinctWithOptions(ctx context.Context, field string, query Options, opts ...DistinctOptions) ([]DistinctValue, error) {
+	if c.schema != nil {
+		fields := c.schema.fields(ctx)
+		if f, ok := c.schema.field(fields, field); !ok || !f.Filterable || f.Hidden {
+			return nil, &PolicyError{Field: field, Action: ActionGroup}
+		}
+	}
+	filters, err := c.prepare(ctx, &query)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.beforeExecute(ctx, &filters, nil); err != nil {
+		return nil, err
+	}
+	query.Fields = append(query.Fields, field)
+	result, err := c.distinct(ctx, c.storageName(field), filters, c.virtualStages(ctx, query), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.afterExecute(ctx, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// distinct runs stages, which compute virtual fields, before matching filters.
+func (c *ReadBuilder) distinct(ctx context.Context, field string, filters bson.D, stages []bson.D, opts ...DistinctOptions) ([]DistinctValue, error) {
+	var opt DistinctOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Limit == 0 && opt.Prefix == "" && !opt.Counts && len(stages) == 0 {
+		values, err := c.collection.Distinct(ctx, field, filters)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]DistinctValue, len(values))
+		for i, value := range values {
+			result[i] = DistinctValue{Value: value}
+		}
+		return result, nil
+	}
+	pipeline := append(mongo.Pipeline{}, stages...)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$match", Value: filters}},
+		bson.D{{Key: "$unwind", Value: "$" + field}},
+	)
+	if opt.Prefix != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{
+			{Key: field, Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(opt.Prefix)}}},
+		}}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: "$" + field},
+		{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+	}}})
+	if opt.Counts {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}})
+	} else {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}})
+	}
+	if opt.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: int64(opt.Limit)}})
+	}
+	cursor, err := c.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	result := []DistinctValue{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+	if !opt.Counts {
+		for i := range result {
+			result[i].Count = 0
+		}
+	}
+	return result, nil
+}
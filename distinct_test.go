@@ -0,0 +1,133 @@
+package querybuilder_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/walkaba/querybuilder"
+	"github.com/walkaba/querybuilder/querybuildertest"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDistinctWithOptions(t *testing.T) {
+	collection, err := querybuildertest.NewCollection(
+		bson.M{"name": "a", "brand": "acme", "price": 10, "tags": bson.A{"new", "sale"}, "secret": "s1"},
+		bson.M{"name": "b", "brand": "acme", "price": 20, "tags": bson.A{"sale"}, "secret": "s2"},
+		bson.M{"name": "c", "brand": "zeta", "price": 70, "tags": bson.A{}, "secret": "s3"},
+		bson.M{"name": "d", "brand": "a.me", "price": 5, "secret": "s4"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &querybuilder.Schema{Fields: map[string]querybuilder.Field{
+		"name":   {Type: "string", Selectable: true},
+		"brand":  {Type: "string", Filterable: true, Selectable: true},
+		"price":  {Type: "int", Filterable: true, Sortable: true, Selectable: true},
+		"tags":   {Type: "array", Filterable: true, Selectable: true},
+		"secret": {Type: "string", Filterable: true, Hidden: true},
+	}}
+	tests := []struct {
+		name    string
+		field   string
+		query   string
+		opts    []querybuilder.DistinctOptions
+		want    []querybuilder.DistinctValue
+		ordered bool
+		policy  bool
+	}{
+		{
+			name:  "plain",
+			field: "brand",
+			want:  []querybuilder.DistinctValue{{Value: "a.me"}, {Value: "acme"}, {Value: "zeta"}},
+		},
+		{
+			name:  "filtered",
+			field: "brand",
+			query: "filter[price][$gte]=10",
+			want:  []querybuilder.DistinctValue{{Value: "acme"}, {Value: "zeta"}},
+		},
+		{
+			name:    "limit",
+			field:   "brand",
+			opts:    []querybuilder.DistinctOptions{{Limit: 2}},
+			want:    []querybuilder.DistinctValue{{Value: "a.me"}, {Value: "acme"}},
+			ordered: true,
+		},
+		{
+			name:    "prefix",
+			field:   "brand",
+			opts:    []querybuilder.DistinctOptions{{Prefix: "ac"}},
+			want:    []querybuilder.DistinctValue{{Value: "acme"}},
+			ordered: true,
+		},
+		{
+			name:    "prefix is literal",
+			field:   "brand",
+			opts:    []querybuilder.DistinctOptions{{Prefix: "a."}},
+			want:    []querybuilder.DistinctValue{{Value: "a.me"}},
+			ordered: true,
+		},
+		{
+			name:    "counts",
+			field:   "brand",
+			opts:    []querybuilder.DistinctOptions{{Counts: true}},
+			want:    []querybuilder.DistinctValue{{Value: "acme", Count: 2}, {Value: "a.me", Count: 1}, {Value: "zeta", Count: 1}},
+			ordered: true,
+		},
+		{
+			name:    "counts of array elements with filter and limit",
+			field:   "tags",
+			query:   "filter[brand]=acme",
+			opts:    []querybuilder.DistinctOptions{{Counts: true, Limit: 1}},
+			want:    []querybuilder.DistinctValue{{Value: "sale", Count: 2}},
+			ordered: true,
+		},
+		{
+			name:   "field that is not filterable",
+			field:  "name",
+			policy: true,
+		},
+		{
+			name:   "hidden field",
+			field:  "secret",
+			policy: true,
+		},
+		{
+			name:   "unknown field",
+			field:  "missing",
+			policy: true,
+		},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := querybuilder.NewSearchBuilder(collection)
+			rb.SetSchema(schema)
+			opt, err := querybuilder.FromQueryString(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := rb.DistinctWithOptions(ctx, tt.field, opt, tt.opts...)
+			if tt.policy {
+				var policyErr *querybuilder.PolicyError
+				if !errors.As(err, &policyErr) {
+					t.Fatalf("got %v, want a PolicyError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ordered {
+				sort.Slice(got, func(i, j int) bool { return fmt.Sprint(got[i].Value) < fmt.Sprint(got[j].Value) })
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
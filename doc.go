@@ -0,0 +1,25 @@
+// Package querybuilder parses JSON:API style query strings into Options and
+// runs them against MongoDB, or compiles them for SQL and Elasticsearch.
+//
+// # Filter values
+//
+// A plain filter value is an equality, and a comma separated list matches any
+// of its elements:
+//
+//	filter[name]=john          name = "john"
+//	filter[status]=a,b         status in ("a", "b")
+//	filter[age][$gte]=18       age >= 18
+//
+// A single plain value may start with a comparison prefix. <, <=, >, >=, !=
+// and <> select the operator and <=> a case-insensitive match, and the value
+// null stands for null:
+//
+//	filter[age]=>30            age > 30
+//	filter[name]=!=john        name != "john"
+//	filter[name]=<=>jo         name matches "jo"
+//	filter[deletedAt]=null     deletedAt is null
+//
+// This is a breaking change from the checkFilter compiler that ParseFilter
+// replaced, which compared such values literally. To match a value that
+// starts with a prefix, name the operator: filter[name][$eq]=<b>.
+package querybuilder
@@ -0,0 +1,242 @@
+package querybuilder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ElasticsearchCompiler compiles parsed Options into an Elasticsearch or
+// OpenSearch search request body.
+type ElasticsearchCompiler struct {
+	Schema *Schema
+}
+
+var regexMetaRE = regexp.MustCompile(`[.*+?()\[\]{}|^$\\]`)
+
+func (c ElasticsearchCompiler) types() map[string]string {
+	if c.Schema == nil {
+		return map[string]string{}
+	}
+	return c.Schema.fieldTypes()
+}
+
+func (c ElasticsearchCompiler) CompileExpr(expr Expr) (interface{}, error) {
+	return c.query(expr, c.types())
+}
+
+func (c ElasticsearchCompiler) Compile(ctx context.Context, opt Options) ([]byte, error) {
+	if c.Schema != nil {
+		if err := c.Schema.Validate(ctx, opt); err != nil {
+			return nil, err
+		}
+		opt = c.Schema.storageOptions(opt)
+	}
+	expr, err := ParseFilter(opt.Filter)
+	if err != nil {
+		return nil, err
+	}
+	query, err := c.query(expr, c.types())
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{"query": query}
+	if source := c.source(ctx, opt.Fields); source != nil {
+		body["_source"] = source
+	}
+	var sort []interface{}
+	for _, field := range opt.Sort {
+		order := "asc"
+		if strings.HasPrefix(field, "-") {
+			order = "desc"
+		}
+		sort = append(sort, map[string]interface{}{
+			strings.TrimLeft(field, "+-"): map[string]string{"order": order},
+		})
+	}
+	if len(sort) > 0 {
+		body["sort"] = sort
+	}
+	if len(opt.After) > 0 {
+		if len(sort) == 0 {
+			return nil, fmt.Errorf("page[after] requires a sort")
+		}
+		after := make([]interface{}, len(opt.After))
+		for i, value := range opt.After {
+			after[i] = validateValue(value)
+		}
+		body["search_after"] = after
+	}
+	if limit, ok := opt.Page["limit"]; ok {
+		body["size"] = limit
+		if offset, ok := opt.Page["offset"]; ok {
+			body["from"] = offset
+		}
+		if skip, ok := opt.Page["skip"]; ok {
+			body["from"] = skip
+		}
+	}
+	if size, ok := opt.Page["size"]; ok {
+		body["size"] = size
+		body["from"] = opt.Page["page"] * size
+	}
+	if len(opt.After) > 0 {
+		delete(body, "from")
+	}
+	return json.Marshal(body)
+}
+
+func (c ElasticsearchCompiler) source(ctx context.Context, fields []string) map[string][]string {
+	source := map[string][]string{}
+	for _, field := range fields {
+		if strings.HasPrefix(field, "-") {
+			source["excludes"] = append(source["excludes"], field[1:])
+			continue
+		}
+		source["includes"] = append(source["includes"], strings.TrimPrefix(field, "+"))
+	}
+	if c.Schema != nil && len(source["includes"]) == 0 {
+		if prj := c.Schema.Projection(ctx, nil); prj != nil {
+			for name := range prj {
+				if !contains(source["excludes"], name, false) {
+					source["excludes"] = append(source["excludes"], name)
+				}
+			}
+		}
+	}
+	if len(source) == 0 {
+		return nil
+	}
+	return source
+}
+
+func (c ElasticsearchCompiler) query(expr Expr, types map[string]string) (map[string]interface{}, error) {
+	switch e := expr.(type) {
+	case nil:
+		return map[string]interface{}{"match_all": map[string]interface{}{}}, nil
+	case And:
+		if len(e.Exprs) == 0 {
+			return map[string]interface{}{"match_all": map[string]interface{}{}}, nil
+		}
+		must, err := c.queries(e.Exprs, types)
+		if err != nil {
+			return nil, err
+		}
+		return boolQuery("must", must), nil
+	case Or:
+		should, err := c.queries(e.Exprs, types)
+		if err != nil {
+			return nil, err
+		}
+		q := boolQuery("should", should)
+		q["bool"].(map[string]interface{})["minimum_should_match"] = 1
+		return q, nil
+	case Not:
+		q, err := c.query(e.Expr, types)
+		if err != nil {
+			return nil, err
+		}
+		return boolQuery("must_not", []interface{}{q}), nil
+	case Compare:
+		value := coerceValue(types[e.Field], e.Value)
+		switch e.Op {
+		case Eq:
+			if value == nil {
+				return boolQuery("must_not", []interface{}{existsQuery(e.Field)}), nil
+			}
+			return map[string]interface{}{"term": map[string]interface{}{e.Field: value}}, nil
+		case Ne:
+			if value == nil {
+				return existsQuery(e.Field), nil
+			}
+			return boolQuery("must_not", []interface{}{map[string]interface{}{"term": map[string]interface{}{e.Field: value}}}), nil
+		case Gt, Gte, Lt, Lte:
+			return map[string]interface{}{"range": map[string]interface{}{
+				e.Field: map[string]interface{}{strings.TrimPrefix(string(e.Op), "$"): value},
+			}}, nil
+		default:
+			return nil, fmt.Errorf("operator %s is not supported", e.Op)
+		}
+	case In:
+		var (
+			values []interface{}
+			null   bool
+		)
+		for _, value := range e.Values {
+			if value == nil {
+				null = true
+				continue
+			}
+			values = append(values, coerceValue(types[e.Field], value))
+		}
+		terms := map[string]interface{}{"terms": map[string]interface{}{e.Field: values}}
+		if !null {
+			return terms, nil
+		}
+		missing := boolQuery("must_not", []interface{}{existsQuery(e.Field)})
+		q := boolQuery("should", []interface{}{terms, missing})
+		q["bool"].(map[string]interface{})["minimum_should_match"] = 1
+		return q, nil
+	case Exists:
+		if e.Exists {
+			return existsQuery(e.Field), nil
+		}
+		return boolQuery("must_not", []interface{}{existsQuery(e.Field)}), nil
+	case ElemMatch:
+		return c.query(prefixFields(e.Field, e.Expr), types)
+	case Match:
+		if regexMetaRE.MatchString(e.Pattern) {
+			return map[string]interface{}{"regexp": map[string]interface{}{
+				e.Field: map[string]interface{}{"value": luceneRegexp(e.Pattern), "case_insensitive": true},
+			}}, nil
+		}
+		return map[string]interface{}{"wildcard": map[string]interface{}{
+			e.Field: map[string]interface{}{"value": "*" + e.Pattern + "*", "case_insensitive": true},
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+// luceneRegexp rewrites a $regex pattern, which matches anywhere in the
+// value unless anchored, for Lucene, which always matches the whole term.
+func luceneRegexp(pattern string) string {
+	start := strings.HasPrefix(pattern, "^")
+	pattern = strings.TrimPrefix(pattern, "^")
+	end := false
+	if strings.HasSuffix(pattern, "$") {
+		escapes := len(pattern) - 1 - len(strings.TrimRight(pattern[:len(pattern)-1], "\\"))
+		if escapes%2 == 0 {
+			pattern, end = pattern[:len(pattern)-1], true
+		}
+	}
+	if !start {
+		pattern = ".*" + pattern
+	}
+	if !end {
+		pattern += ".*"
+	}
+	return pattern
+}
+
+func (c ElasticsearchCompiler) queries(exprs []Expr, types map[string]string) ([]interface{}, error) {
+	var queries []interface{}
+	for _, child := range exprs {
+		q, err := c.query(child, types)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+func boolQuery(clause string, queries []interface{}) map[string]interface{} {
+	return map[string]interface{}{"bool": map[string]interface{}{clause: queries}}
+}
+
+func existsQuery(field string) map[string]interface{} {
+	return map[string]interface{}{"exists": map[string]interface{}{"field": field}}
+}
@@ -0,0 +1,165 @@
+package querybuilder
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestElasticsearchCompile(t *testing.T) {
+	schema := &Schema{Fields: map[string]Field{
+		"name":   {Type: "string", Filterable: true, Sortable: true, Selectable: true},
+		"age":    {Type: "int", Filterable: true, Sortable: true, Selectable: true},
+		"status": {Type: "string", Filterable: true, Selectable: true},
+		"secret": {Type: "string", Filterable: true, Hidden: true},
+	}}
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "match all",
+			query: "",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"match_all":{}}}`,
+		},
+		{
+			name:  "must",
+			query: "filter[name]=john&filter[age][$gte]=18",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"bool":{"must":[{"range":{"age":{"gte":18}}},{"term":{"name":"john"}}]}}}`,
+		},
+		{
+			name:  "should",
+			query: "filter[$or][0][name]=a&filter[$or][1][age][$lt]=5",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"bool":{"minimum_should_match":1,"should":[{"term":{"name":"a"}},{"range":{"age":{"lt":5}}}]}}}`,
+		},
+		{
+			name:  "must not",
+			query: "filter[name][$ne]=x",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"bool":{"must_not":[{"term":{"name":"x"}}]}}}`,
+		},
+		{
+			name:  "range",
+			query: "filter[age][$gt]=1&filter[age][$lte]=9",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"bool":{"must":[{"range":{"age":{"gt":1}}},{"range":{"age":{"lte":9}}}]}}}`,
+		},
+		{
+			name:  "terms",
+			query: "filter[status]=a,b",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"terms":{"status":["a","b"]}}}`,
+		},
+		{
+			name:  "null",
+			query: "filter[status]=null",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"bool":{"must_not":[{"exists":{"field":"status"}}]}}}`,
+		},
+		{
+			name:  "terms with null",
+			query: "filter[status]=a,null",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"bool":{"minimum_should_match":1,"should":[{"terms":{"status":["a"]}},{"bool":{"must_not":[{"exists":{"field":"status"}}]}}]}}}`,
+		},
+		{
+			name:  "includes",
+			query: "fields=name,age",
+			want:  `{"_source":{"includes":["name","age"]},"query":{"match_all":{}}}`,
+		},
+		{
+			name:  "excludes with hidden fields",
+			query: "fields=-age",
+			want:  `{"_source":{"excludes":["age","secret"]},"query":{"match_all":{}}}`,
+		},
+		{
+			name:  "limit and offset",
+			query: "page[limit]=10&page[offset]=20",
+			want:  `{"_source":{"excludes":["secret"]},"from":20,"query":{"match_all":{}},"size":10}`,
+		},
+		{
+			name:  "page and size",
+			query: "page[size]=10&page[page]=2",
+			want:  `{"_source":{"excludes":["secret"]},"from":20,"query":{"match_all":{}},"size":10}`,
+		},
+		{
+			name:  "search after",
+			query: "sort=-age,name&page[after]=30,bob&page[size]=5&page[page]=2",
+			want:  `{"_source":{"excludes":["secret"]},"query":{"match_all":{}},"search_after":[30,"bob"],"size":5,"sort":[{"age":{"order":"desc"}},{"name":{"order":"asc"}}]}`,
+		},
+		{
+			name:    "search after without sort",
+			query:   "page[after]=30",
+			wantErr: true,
+		},
+		{
+			name:    "hidden field",
+			query:   "fields=secret",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := FromQueryString(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ElasticsearchCompiler{Schema: schema}.Compile(context.Background(), opt)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %s, want an error", body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %s, want %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestElasticsearchMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    map[string]interface{}
+	}{
+		{"john", wildcardQuery("*john*")},
+		{"^jo.n", regexpQuery("jo.n.*")},
+		{"jo.n$", regexpQuery(".*jo.n")},
+		{"^jo.n$", regexpQuery("jo.n")},
+		{"j.hn", regexpQuery(".*j.hn.*")},
+		{`cost\$`, regexpQuery(`.*cost\$.*`)},
+		{`path\\$`, regexpQuery(`.*path\\`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := ElasticsearchCompiler{}.CompileExpr(Match{Field: "name", Pattern: tt.pattern})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func regexpQuery(value string) map[string]interface{} {
+	return map[string]interface{}{"regexp": map[string]interface{}{
+		"name": map[string]interface{}{"value": value, "case_insensitive": true},
+	}}
+}
+
+func wildcardQuery(value string) map[string]interface{} {
+	return map[string]interface{}{"wildcard": map[string]interface{}{
+		"name": map[string]interface{}{"value": value, "case_insensitive": true},
+	}}
+}
@@ -0,0 +1,167 @@
+package querybuilder
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var keyEscaper = strings.NewReplacer("%5B", "[", "%5D", "]", "%24", "$")
+
+// Encode returns the canonical query string for o. Parameters are written in a
+// fixed order with map keys sorted, so equal Options always encode to the same
+// string, and FromQueryString(o.Encode()) yields equal Options. Commas inside
+// values are written as \, so they are not read as list separators.
+func (o Options) Encode() string {
+	return o.encode(encodePage(o.Page, o.After))
+}
+
+// encode writes every parameter except the page ones, which are appended as
+// given so pagination strategies can supply their own.
+func (o Options) encode(page string) string {
+	var params []string
+	params = appendTree(params, "filter", nil, o.Filter)
+	params = appendList(params, "fields", o.Fields)
+	for _, typ := range sortedKeys(o.FieldSets) {
+		params = appendList(params, "fields["+escapeKey(typ)+"]", o.FieldSets[typ])
+	}
+	params = appendList(params, "include", o.Include)
+	params = appendList(params, "group", o.Group)
+	for _, field := range sortedKeys(o.Aggregate) {
+		params = append(params, "aggregate["+escapeKey(field)+"]="+url.QueryEscape(o.Aggregate[field]))
+	}
+	params = appendTree(params, "having", nil, o.Having)
+	for _, facet := range o.Facets {
+		params = append(params, "facet["+escapeKey(facet.Field)+"]="+url.QueryEscape(facet.spec()))
+	}
+	params = appendList(params, "sort", o.Sort)
+	if page != "" {
+		params = append(params, page)
+	}
+	return strings.Join(params, "&")
+}
+
+func encodePage(page map[string]int, after []string) string {
+	var params []string
+	keys := sortedKeys(page)
+	if len(after) > 0 {
+		keys = append(keys, "after")
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		if key == "after" && len(after) > 0 {
+			params = appendList(params, "page[after]", after)
+			continue
+		}
+		params = append(params, "page["+escapeKey(key)+"]="+strconv.Itoa(page[key]))
+	}
+	return strings.Join(params, "&")
+}
+
+func (f Facet) spec() string {
+	var parts []string
+	if len(f.Buckets) > 0 {
+		bounds := make([]string, len(f.Buckets))
+		for i, bound := range f.Buckets {
+			bounds[i] = strconv.FormatFloat(bound, 'f', -1, 64)
+		}
+		parts = append(parts, "buckets:"+strings.Join(bounds, ","))
+	} else {
+		parts = append(parts, "count")
+	}
+	if f.Exclude {
+		parts = append(parts, "exclude")
+	}
+	return strings.Join(parts, ";")
+}
+
+// appendTree writes a filter or having map. Nested maps and arrays become
+// bracketed path segments; array holes left by sparse indexes are skipped.
+func appendTree(params []string, typ string, path []string, value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return params
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			params = appendTree(params, typ, append(path[:len(path):len(path)], key), v[key])
+		}
+		return params
+	case []interface{}:
+		if isValueList(v) {
+			values := make([]string, len(v))
+			for i, item := range v {
+				values[i] = encodeValue(item)
+			}
+			return appendList(params, typ+"["+escapeKey(strings.Join(path, "]["))+"]", values)
+		}
+		for i, item := range v {
+			params = appendTree(params, typ, append(path[:len(path):len(path)], strconv.Itoa(i)), item)
+		}
+		return params
+	}
+	key := typ + "[" + escapeKey(strings.Join(path, "][")) + "]"
+	if v, ok := value.([]string); ok {
+		return appendList(params, key, v)
+	}
+	return append(params, key+"="+url.QueryEscape(escapeCommas(encodeValue(value))))
+}
+
+// isValueList reports whether list holds the typed values of one term, as
+// OptionsBuilder stores them, rather than the branches or elements of a
+// bracketed array.
+func isValueList(list []interface{}) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		switch item.(type) {
+		case nil, map[string]interface{}, []interface{}, []string:
+			return false
+		}
+	}
+	return true
+}
+
+func encodeValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func appendList(params []string, key string, values []string) []string {
+	if len(values) == 0 {
+		return params
+	}
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = url.QueryEscape(escapeCommas(value))
+	}
+	return append(params, key+"="+strings.Join(escaped, ","))
+}
+
+func escapeCommas(value string) string {
+	return strings.ReplaceAll(value, ",", `\,`)
+}
+
+func escapeKey(key string) string {
+	return keyEscaper.Replace(url.QueryEscape(key))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,86 @@
+package querybuilder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"empty", ""},
+		{"filter", "filter[name]=john"},
+		{"operator", "filter[age][$gte]=21&filter[name][$like]=jo"},
+		{"list", "filter[status]=active,pending"},
+		{"escaped value", "filter[name]=a%26b%3Dc%2Bd%25e"},
+		{"link header characters", "filter[name]=%3Cb%3E%3B%22q%22"},
+		{"space", "filter[name]=john+smith"},
+		{"escaped comma", `filter[name]=Smith\,+John`},
+		{"escaped comma in list", `filter[name]=a\,b,c&fields=x\,y`},
+		{"escaped comma in or", `filter[$or][0][name]=a\,b&filter[$or][1][name]=c\,d,e`},
+		{"escaped backslash before comma", `filter[name][$regex]=a\\,b`},
+		{"prefix", "filter[age]=>=21"},
+		{"nested", "filter[address][city]=Lisbon"},
+		{"or", "filter[$or][0][name]=john&filter[$or][1][age][$lt]=30"},
+		{"fields", "fields=name,-secret"},
+		{"field sets", "fields[users]=name&fields[teams]=title&include=team"},
+		{"aggregation", "group=brand&aggregate[total]=sum:price&aggregate[n]=count&having[total][$gt]=10&sort=-total"},
+		{"facets", "facets=brand&facet[price]=buckets:0,50,100;exclude"},
+		{"sort", "sort=-createdAt,name"},
+		{"page", "page[page]=2&page[size]=10"},
+		{"limit", "page[limit]=5&page[offset]=10"},
+		{"after", "page[size]=10&page[after]=abc,12"},
+		{"everything", "filter[name]=john&fields=name&include=team&sort=name&page[page]=1&page[size]=5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := FromQueryString(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded := opt.Encode()
+			parsed, err := FromQueryString(encoded)
+			if err != nil {
+				t.Fatalf("%q does not parse: %v", encoded, err)
+			}
+			if !reflect.DeepEqual(parsed, opt) {
+				t.Errorf("%q parsed as %+v, want %+v", encoded, parsed, opt)
+			}
+			if again := parsed.Encode(); again != encoded {
+				t.Errorf("encoded again as %q, want %q", again, encoded)
+			}
+		})
+	}
+}
+
+func TestEncodeCommaValues(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *OptionsBuilder
+		want []string
+	}{
+		{"value", New().Where("name", Eq, "Smith, John"), []string{"Smith, John"}},
+		{"list", New().Where("name", Eq, []string{"Smith, John", "Doe"}), []string{"Smith, John", "Doe"}},
+		{"operator", New().Where("name", Ne, "a,b"), []string{"a,b"}},
+		{"or", New().Or(New().Where("name", Eq, "Smith, John")), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := tt.b.Options()
+			parsed, err := FromQueryString(opt.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(parsed.Filter, opt.Filter) {
+				t.Errorf("%q parsed as %v, want %v", opt.Encode(), parsed.Filter, opt.Filter)
+			}
+			for _, values := range parsed.Filter {
+				if tt.want != nil && !reflect.DeepEqual(values, tt.want) {
+					t.Errorf("got %q, want %q", values, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package querybuilder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Facet is parsed from facets=brand,category for value counts, and from
+// facet[price]=buckets:0,50,100 for range buckets. Appending ";exclude" to a
+// facet[...] value computes its counts without the facet's own filter.
+type Facet struct {
+	Field   string    `json:"field"`
+	Buckets []float64 `json:"buckets,omitempty"`
+	Exclude bool      `json:"exclude,omitempty"`
+}
+
+type FacetCount struct {
+	Value interface{} `json:"value" bson:"_id"`
+	Count int64       `json:"count" bson:"count"`
+}
+
+func parseFacet(field string, spec string) (Facet, error) {
+	facet := Facet{Field: field}
+	for _, part := range strings.Split(spec, ";") {
+		switch {
+		case part == "exclude":
+			facet.Exclude = true
+		case part == "count" || part == "":
+		case strings.HasPrefix(part, "buckets:"):
+			for _, bound := range commaRE.Split(strings.TrimPrefix(part, "buckets:"), -1) {
+				value, err := strconv.ParseFloat(bound, 64)
+				if err != nil {
+					return facet, fmt.Errorf("invalid bucket boundary %s", bound)
+				}
+				if n := len(facet.Buckets); n > 0 && value <= facet.Buckets[n-1] {
+					return facet, fmt.Errorf("bucket boundaries of %s must be ascending", field)
+				}
+				facet.Buckets = append(facet.Buckets, value)
+			}
+			if len(facet.Buckets) < 2 {
+				return facet, fmt.Errorf("facet %s needs at least two bucket boundaries", field)
+			}
+		default:
+			return facet, fmt.Errorf("invalid facet %s", spec)
+		}
+	}
+	return facet, nil
+}
+
+func setFacet(o *Options, facet Facet) {
+	for i, f := range o.Facets {
+		if f.Field == facet.Field {
+			o.Facets[i] = facet
+			return
+		}
+	}
+	o.Facets = append(o.Facets, facet)
+}
+
+func (f Facet) stage() bson.D {
+	if len(f.Buckets) == 0 {
+		return bson.D{{Key: "$sortByCount", Value: "$" + f.Field}}
+	}
+	return bson.D{{Key: "$bucket", Value: bson.D{
+		{Key: "groupBy", Value: "$" + f.Field},
+		{Key: "boundaries", Value: f.Buckets},
+		{Key: "default", Value: "other"},
+		{Key: "output", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}},
+	}}}
+}
+
+func withoutField(filter map[string]interface{}, field string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key, value := range filter {
+		if strings.Split(key, "][")[0] == field {
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// facetPipeline compiles the requested facets into a single $facet stage.
+// Facets that exclude their own filter carry their own $match, which the
+// BeforeExecute hooks see like the main filter.
+func (c *builderConfig) facetPipeline(ctx context.Context, opt Options, filters bson.D) (mongo.Pipeline, error) {
+	exclude := false
+	for _, facet := range opt.Facets {
+		exclude = exclude || facet.Exclude
+	}
+	pipeline := append(mongo.Pipeline{}, c.virtualStages(ctx, opt)...)
+	if !exclude {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filters}})
+	}
+	facets := bson.D{}
+	for _, facet := range opt.Facets {
+		stages := bson.A{}
+		if exclude {
+			match := filters
+			if facet.Exclude {
+				var err error
+				match = bson.D{}
+				if rest := withoutField(opt.Filter, facet.Field); len(rest) > 0 {
+					match, err = c.queryBuilder().Filter(c.storageOptions(Options{Filter: rest}))
+					if err != nil {
+						return nil, err
+					}
+				}
+				match, err = c.scopeFilter(ctx, match)
+				if err != nil {
+					return nil, err
+				}
+				if err := c.beforeExecute(ctx, &match, nil); err != nil {
+					return nil, err
+				}
+			}
+			stages = append(stages, bson.D{{Key: "$match", Value: match}})
+		}
+		stored := facet
+		stored.Field = c.storageName(facet.Field)
+		stages = append(stages, stored.stage())
+		facets = append(facets, bson.E{Key: facet.Field, Value: stages})
+	}
+	return append(pipeline, bson.D{{Key: "$facet", Value: facets}}), nil
+}
+
+func (c *PaginationBuilder) facets(ctx context.Context, opt Options, filters bson.D) (map[string][]FacetCount, error) {
+	pipeline, err := c.facetPipeline(ctx, opt, filters)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := c.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var results []map[string][]FacetCount
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return map[string][]FacetCount{}, nil
+	}
+	return results[0], nil
+}
@@ -0,0 +1,98 @@
+package querybuilder
+
+import "strings"
+
+type filterTerm struct {
+	Field    string
+	Operator string
+	Values   []interface{}
+}
+
+func filterTerms(filter map[string]interface{}) []filterTerm {
+	var terms []filterTerm
+	for key, value := range filter {
+		if key == "$or" {
+			if list, ok := value.([]interface{}); ok {
+				for _, item := range list {
+					if m, ok := item.(map[string]interface{}); ok {
+						terms = append(terms, filterTerms(m)...)
+					}
+				}
+			}
+			continue
+		}
+		keys := strings.Split(key, "][")
+		if len(keys) > 1 && strings.HasPrefix(keys[len(keys)-1], "$") {
+			terms = append(terms, filterTerm{
+				Field:    strings.Join(keys[:len(keys)-1], "."),
+				Operator: keys[len(keys)-1],
+				Values:   termValues(value),
+			})
+			continue
+		}
+		terms = append(terms, nestedTerms(strings.Join(keys, "."), value)...)
+	}
+	return terms
+}
+
+func nestedTerms(field string, value interface{}) []filterTerm {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		values := termValues(value)
+		operator := "$in"
+		if len(values) == 1 {
+			operator = "$eq"
+			if str, ok := values[0].(string); ok {
+				operator = prefixOperator(str)
+			}
+		}
+		return []filterTerm{{Field: field, Operator: operator, Values: values}}
+	}
+	var terms []filterTerm
+	for key, sub := range m {
+		if strings.HasPrefix(key, "$") {
+			terms = append(terms, filterTerm{Field: field, Operator: key, Values: termValues(sub)})
+			continue
+		}
+		terms = append(terms, nestedTerms(field+"."+key, sub)...)
+	}
+	return terms
+}
+
+func termValues(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, s := range v {
+			values[i] = s
+		}
+		return values
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+var operatorPrefixes = []string{"<=>", "<>", "<=", ">=", "!=", "<", ">"}
+
+func prefixOperator(value string) string {
+	for _, prefix := range operatorPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			if prefix == "<=>" {
+				return "$like"
+			}
+			return compareOperator(prefix)
+		}
+	}
+	return "$eq"
+}
+
+func trimOperatorPrefix(value string) string {
+	for _, prefix := range operatorPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return value[len(prefix):]
+		}
+	}
+	return value
+}
@@ -0,0 +1,104 @@
+package querybuilder
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Hook rewrites queries on their way through a builder. Any of the functions
+// may be nil. Global hooks run before the hooks of a builder, each group in
+// registration order, and the first error stops the query and is returned
+// to the caller.
+//
+// AfterParse also runs for options passed to the WithOptions methods, and
+// runs before the limits and schema are checked. BeforeExecute runs before
+// any pipeline is built from the filter, and for writes by id on their id
+// filter. It receives nil find options for queries that are not a find, and
+// AfterExecute receives the value the builder method is about to return.
+type Hook struct {
+	BeforeParse   func(ctx context.Context, payload string) (string, error)
+	AfterParse    func(ctx context.Context, opt *Options) error
+	BeforeExecute func(ctx context.Context, filter *bson.D, opts *options.FindOptions) error
+	AfterExecute  func(ctx context.Context, result interface{}) error
+}
+
+var globalHooks struct {
+	sync.RWMutex
+	hooks []Hook
+}
+
+// RegisterHook adds a hook that runs for every builder.
+func RegisterHook(hook Hook) {
+	globalHooks.Lock()
+	defer globalHooks.Unlock()
+	globalHooks.hooks = append(globalHooks.hooks, hook)
+}
+
+// AddHook adds a hook to the builder. Unlike RegisterHook it is not
+// synchronized, so hooks must be added while the builder is set up, before it
+// serves queries.
+func (c *builderConfig) AddHook(hook Hook) {
+	c.hooks = append(c.hooks, hook)
+}
+
+func (c *builderConfig) allHooks() []Hook {
+	globalHooks.RLock()
+	defer globalHooks.RUnlock()
+	if len(globalHooks.hooks) == 0 {
+		return c.hooks
+	}
+	return append(append([]Hook{}, globalHooks.hooks...), c.hooks...)
+}
+
+func (c *builderConfig) beforeParse(ctx context.Context, payload string) (string, error) {
+	for _, hook := range c.allHooks() {
+		if hook.BeforeParse == nil {
+			continue
+		}
+		var err error
+		payload, err = hook.BeforeParse(ctx, payload)
+		if err != nil {
+			return payload, err
+		}
+	}
+	return payload, nil
+}
+
+func (c *builderConfig) afterParse(ctx context.Context, opt *Options) error {
+	for _, hook := range c.allHooks() {
+		if hook.AfterParse == nil {
+			continue
+		}
+		if err := hook.AfterParse(ctx, opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *builderConfig) beforeExecute(ctx context.Context, filter *bson.D, opts *options.FindOptions) error {
+	for _, hook := range c.allHooks() {
+		if hook.BeforeExecute == nil {
+			continue
+		}
+		if err := hook.BeforeExecute(ctx, filter, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *builderConfig) afterExecute(ctx context.Context, result interface{}) error {
+	for _, hook := range c.allHooks() {
+		if hook.AfterExecute == nil {
+			continue
+		}
+		if err := hook.AfterExecute(ctx, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,455 @@
+package querybuilder_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/walkaba/querybuilder"
+	"github.com/walkaba/querybuilder/querybuildertest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tenantHook restricts every filter to tenant a and selects only the name of
+// single document lookups.
+var tenantHook = querybuilder.Hook{
+	BeforeExecute: func(ctx context.Context, filter *bson.D, opts *options.FindOptions) error {
+		*filter = bson.D{{Key: "$and", Value: bson.A{*filter, bson.D{{Key: "tenant", Value: "a"}}}}}
+		if opts != nil && opts.Limit != nil && *opts.Limit == 1 {
+			opts.SetProjection(map[string]int{"name": 1})
+		}
+		return nil
+	},
+}
+
+var hookSchema = &querybuilder.Schema{Fields: map[string]querybuilder.Field{
+	"_id":   {Type: "objectId", Filterable: true, Selectable: true},
+	"name":  {Type: "string", Filterable: true, Sortable: true, Selectable: true},
+	"brand": {Type: "string", Filterable: true, Selectable: true},
+	"label": {Type: "string", Filterable: true, Selectable: true, Expression: bson.D{
+		{Key: "$concat", Value: bson.A{"$brand", ":", "$name"}},
+	}},
+}}
+
+func TestHookBeforeExecute(t *testing.T) {
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	docs := []interface{}{
+		bson.M{"_id": ids[0], "name": "x", "brand": "acme", "tenant": "a"},
+		bson.M{"_id": ids[1], "name": "y", "brand": "acme", "tenant": "b"},
+		bson.M{"_id": ids[2], "name": "z", "brand": "zeta", "tenant": "a"},
+	}
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		run  func(t *testing.T, collection querybuilder.Collection) int
+		want int
+	}{
+		{
+			name: "virtual find",
+			run: func(t *testing.T, collection querybuilder.Collection) int {
+				rb := querybuilder.NewSearchBuilder(collection)
+				rb.SetSchema(hookSchema)
+				rb.AddHook(tenantHook)
+				cursor, err := rb.FindContext(ctx, "fields=label")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []bson.M
+				if err := cursor.All(ctx, &got); err != nil {
+					t.Fatal(err)
+				}
+				return len(got)
+			},
+			want: 2,
+		},
+		{
+			name: "virtual search",
+			run: func(t *testing.T, collection querybuilder.Collection) int {
+				rb := querybuilder.NewSearchBuilder(collection)
+				rb.SetSchema(hookSchema)
+				rb.AddHook(tenantHook)
+				result, err := rb.SearchContext(ctx, "filter[label]=acme:y")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Err() != nil {
+					return 0
+				}
+				return 1
+			},
+			want: 0,
+		},
+		{
+			name: "find one projection",
+			run: func(t *testing.T, collection querybuilder.Collection) int {
+				rb := querybuilder.NewSearchBuilder(collection)
+				rb.AddHook(tenantHook)
+				result, err := rb.FindOneContext(ctx, ids[0].Hex())
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got bson.M
+				if err := result.Decode(&got); err != nil {
+					t.Fatal(err)
+				}
+				return len(got)
+			},
+			want: 2,
+		},
+		{
+			name: "excluded facet",
+			run: func(t *testing.T, collection querybuilder.Collection) int {
+				pb := querybuilder.NewPaginationBuilder(collection, "/items")
+				pb.SetSchema(hookSchema)
+				pb.AddHook(tenantHook)
+				out, err := pb.PaginationContext(ctx, "filter[brand]=zeta&facet[brand]=exclude")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var meta querybuilder.Meta
+				if err := json.Unmarshal(out.Meta, &meta); err != nil {
+					t.Fatal(err)
+				}
+				var n int64
+				for _, count := range meta.Facets["brand"] {
+					n += count.Count
+				}
+				return int(n)
+			},
+			want: 2,
+		},
+		{
+			name: "update",
+			run: func(t *testing.T, collection querybuilder.Collection) int {
+				wb := querybuilder.NewWriteBuilder(collection)
+				wb.AddHook(tenantHook)
+				if _, err := wb.UpdateOneContext(ctx, ids[1].Hex(), bson.M{"$set": bson.M{"name": "changed"}}); err != nil {
+					t.Fatal(err)
+				}
+				return countName(collection, "changed")
+			},
+			want: 0,
+		},
+		{
+			name: "delete",
+			run: func(t *testing.T, collection querybuilder.Collection) int {
+				wb := querybuilder.NewWriteBuilder(collection)
+				wb.AddHook(tenantHook)
+				if err := wb.DeleteOneContext(ctx, ids[1].Hex()); err != nil {
+					t.Fatal(err)
+				}
+				n, err := collection.CountDocuments(ctx, bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$exists", Value: true}}}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return int(n)
+			},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection, err := querybuildertest.NewCollection(docs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.run(t, collection); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func countName(collection querybuilder.Collection, name string) int {
+	n, _ := collection.CountDocuments(context.Background(), bson.D{{Key: "name", Value: name}})
+	return int(n)
+}
+
+var errStop = errors.New("stop")
+
+type hookLogKey struct{}
+
+// hookLog records the hooks that ran for one query, in order.
+type hookLog struct {
+	calls []string
+	fail  string
+}
+
+// recordingHook logs every stage it runs under name, and fails the stage
+// named by log.fail when it is the hook that should fail.
+func recordingHook(name string) querybuilder.Hook {
+	record := func(ctx context.Context, stage string) error {
+		log, ok := ctx.Value(hookLogKey{}).(*hookLog)
+		if !ok {
+			return nil
+		}
+		log.calls = append(log.calls, name+":"+stage)
+		if log.fail == name+":"+stage {
+			return errStop
+		}
+		return nil
+	}
+	return querybuilder.Hook{
+		BeforeParse: func(ctx context.Context, payload string) (string, error) {
+			return payload, record(ctx, "BeforeParse")
+		},
+		AfterParse: func(ctx context.Context, opt *querybuilder.Options) error {
+			return record(ctx, "AfterParse")
+		},
+		BeforeExecute: func(ctx context.Context, filter *bson.D, opts *options.FindOptions) error {
+			return record(ctx, "BeforeExecute")
+		},
+		AfterExecute: func(ctx context.Context, result interface{}) error {
+			return record(ctx, "AfterExecute")
+		},
+	}
+}
+
+// registerGlobalHook registers the global recording hook once per test
+// binary. It only records for contexts carrying a hookLog, so it leaves the
+// other tests alone.
+var registerGlobalHook = sync.OnceFunc(func() {
+	querybuilder.RegisterHook(recordingHook("global"))
+})
+
+func TestHookChain(t *testing.T) {
+	registerGlobalHook()
+	all := []string{
+		"global:BeforeParse", "a:BeforeParse", "b:BeforeParse",
+		"global:AfterParse", "a:AfterParse", "b:AfterParse",
+		"global:BeforeExecute", "a:BeforeExecute", "b:BeforeExecute",
+		"global:AfterExecute", "a:AfterExecute", "b:AfterExecute",
+	}
+	tests := []struct {
+		name string
+		fail string
+		want []string
+	}{
+		{name: "order", want: all},
+		{name: "global error", fail: "global:BeforeParse", want: all[:1]},
+		{name: "builder error", fail: "a:AfterParse", want: all[:5]},
+		{name: "last hook error", fail: "b:BeforeExecute", want: all[:9]},
+		{name: "after execute error", fail: "a:AfterExecute", want: all[:11]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection, err := querybuildertest.NewCollection(bson.M{"name": "x"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rb := querybuilder.NewSearchBuilder(collection)
+			rb.AddHook(recordingHook("a"))
+			rb.AddHook(recordingHook("b"))
+			log := &hookLog{fail: tt.fail}
+			ctx := context.WithValue(context.Background(), hookLogKey{}, log)
+			_, err = rb.FindContext(ctx, "")
+			if tt.fail == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.fail != "" && !errors.Is(err, errStop) {
+				t.Fatalf("got %v, want the hook error", err)
+			}
+			if !reflect.DeepEqual(log.calls, tt.want) {
+				t.Errorf("got %v, want %v", log.calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookRewrite(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		query string
+		hook  querybuilder.Hook
+		want  []string
+	}{
+		{
+			name:  "none",
+			query: "sort=name",
+			want:  []string{"x", "y", "z"},
+		},
+		{
+			name:  "before parse replaces the query",
+			query: "sort=name",
+			hook: querybuilder.Hook{BeforeParse: func(ctx context.Context, payload string) (string, error) {
+				return payload + "&filter[brand]=acme", nil
+			}},
+			want: []string{"x", "y"},
+		},
+		{
+			name:  "before parse maps a deprecated field",
+			query: "filter[make]=zeta",
+			hook: querybuilder.Hook{BeforeParse: func(ctx context.Context, payload string) (string, error) {
+				return strings.ReplaceAll(payload, "filter[make]", "filter[brand]"), nil
+			}},
+			want: []string{"z"},
+		},
+		{
+			name:  "after parse adds a default sort",
+			query: "filter[brand]=acme",
+			hook: querybuilder.Hook{AfterParse: func(ctx context.Context, opt *querybuilder.Options) error {
+				if len(opt.Sort) == 0 {
+					opt.Sort = []string{"-name"}
+				}
+				return nil
+			}},
+			want: []string{"y", "x"},
+		},
+		{
+			name:  "after parse forces a filter",
+			query: "sort=name&filter[brand]=zeta",
+			hook: querybuilder.Hook{AfterParse: func(ctx context.Context, opt *querybuilder.Options) error {
+				opt.Filter["brand"] = []string{"acme"}
+				return nil
+			}},
+			want: []string{"x", "y"},
+		},
+		{
+			name:  "after parse caps the page size",
+			query: "sort=name&page[size]=50",
+			hook: querybuilder.Hook{AfterParse: func(ctx context.Context, opt *querybuilder.Options) error {
+				opt.Page["size"] = min(opt.Page["size"], 1)
+				return nil
+			}},
+			want: []string{"x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection, err := querybuildertest.NewCollection(
+				bson.M{"name": "x", "brand": "acme"},
+				bson.M{"name": "y", "brand": "acme"},
+				bson.M{"name": "z", "brand": "zeta"},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rb := querybuilder.NewSearchBuilder(collection)
+			rb.SetSchema(hookSchema)
+			rb.AddHook(tt.hook)
+			cursor, err := rb.FindContext(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cursorNames(ctx, cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookAfterExecute(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		run   func(collection querybuilder.Collection, hook querybuilder.Hook) error
+		check func(t *testing.T, result interface{})
+	}{
+		{
+			name: "find",
+			run: func(collection querybuilder.Collection, hook querybuilder.Hook) error {
+				rb := querybuilder.NewSearchBuilder(collection)
+				rb.AddHook(hook)
+				_, err := rb.FindContext(ctx, "filter[brand]=acme")
+				return err
+			},
+			check: func(t *testing.T, result interface{}) {
+				cursor, ok := result.(*mongo.Cursor)
+				if !ok {
+					t.Fatalf("got %T, want a cursor", result)
+				}
+				if n := cursor.RemainingBatchLength(); n != 2 {
+					t.Errorf("the cursor holds %d documents, want 2", n)
+				}
+			},
+		},
+		{
+			name: "search",
+			run: func(collection querybuilder.Collection, hook querybuilder.Hook) error {
+				rb := querybuilder.NewSearchBuilder(collection)
+				rb.AddHook(hook)
+				_, err := rb.SearchContext(ctx, "filter[brand]=zeta")
+				return err
+			},
+			check: func(t *testing.T, result interface{}) {
+				single, ok := result.(*mongo.SingleResult)
+				if !ok {
+					t.Fatalf("got %T, want a single result", result)
+				}
+				var doc bson.M
+				if err := single.Decode(&doc); err != nil || doc["name"] != "z" {
+					t.Errorf("got %v, %v, want z", doc, err)
+				}
+			},
+		},
+		{
+			name: "pagination",
+			run: func(collection querybuilder.Collection, hook querybuilder.Hook) error {
+				pb := querybuilder.NewPaginationBuilder(collection, "/items")
+				pb.AddHook(hook)
+				_, err := pb.PaginationContext(ctx, "filter[brand]=acme&page[size]=1")
+				return err
+			},
+			check: func(t *testing.T, result interface{}) {
+				out, ok := result.(*querybuilder.OutPagination)
+				if !ok {
+					t.Fatalf("got %T, want pagination output", result)
+				}
+				if out.Total != 2 {
+					t.Errorf("total = %d, want 2", out.Total)
+				}
+			},
+		},
+		{
+			name: "distinct",
+			run: func(collection querybuilder.Collection, hook querybuilder.Hook) error {
+				rb := querybuilder.NewSearchBuilder(collection)
+				rb.AddHook(hook)
+				_, err := rb.DistinctContext(ctx, "brand", "")
+				return err
+			},
+			check: func(t *testing.T, result interface{}) {
+				values, ok := result.([]querybuilder.DistinctValue)
+				if !ok {
+					t.Fatalf("got %T, want distinct values", result)
+				}
+				if len(values) != 2 {
+					t.Errorf("got %v, want 2 values", values)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection, err := querybuildertest.NewCollection(
+				bson.M{"name": "x", "brand": "acme"},
+				bson.M{"name": "y", "brand": "acme"},
+				bson.M{"name": "z", "brand": "zeta"},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result interface{}
+			hook := querybuilder.Hook{AfterExecute: func(ctx context.Context, r interface{}) error {
+				result = r
+				return nil
+			}}
+			if err := tt.run(collection, hook); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, result)
+		})
+	}
+}
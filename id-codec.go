@@ -0,0 +1,182 @@
+package querybuilder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidID = errors.New("invalid id")
+
+type IDCodec interface {
+	Parse(id string) (interface{}, error)
+	Generate() interface{}
+	Format(id interface{}) (string, error)
+}
+
+type ObjectIDCodec struct{}
+
+func (ObjectIDCodec) Parse(id string) (interface{}, error) {
+	return primitive.ObjectIDFromHex(id)
+}
+
+func (ObjectIDCodec) Generate() interface{} {
+	return primitive.NewObjectID()
+}
+
+func (ObjectIDCodec) Format(id interface{}) (string, error) {
+	objectID, ok := id.(primitive.ObjectID)
+	if !ok {
+		return "", fmt.Errorf("invalid object id %v", id)
+	}
+	return objectID.Hex(), nil
+}
+
+type UUIDCodec struct{}
+
+func (UUIDCodec) Parse(id string) (interface{}, error) {
+	raw := strings.ReplaceAll(id, "-", "")
+	if len(raw) != 32 {
+		return primitive.Binary{}, fmt.Errorf("invalid uuid %s", id)
+	}
+	data, err := hex.DecodeString(raw)
+	if err != nil {
+		return primitive.Binary{}, fmt.Errorf("invalid uuid %s", id)
+	}
+	return primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: data}, nil
+}
+
+func (UUIDCodec) Generate() interface{} {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	data[6] = (data[6] & 0x0f) | 0x40
+	data[8] = (data[8] & 0x3f) | 0x80
+	return primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: data}
+}
+
+func (UUIDCodec) Format(id interface{}) (string, error) {
+	binary, ok := id.(primitive.Binary)
+	if !ok || binary.Subtype != bson.TypeBinaryUUID || len(binary.Data) != 16 {
+		return "", fmt.Errorf("invalid uuid %v", id)
+	}
+	h := hex.EncodeToString(binary.Data)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+type StringCodec struct{}
+
+func (StringCodec) Parse(id string) (interface{}, error) {
+	if id == "" {
+		return "", fmt.Errorf("invalid id %s", id)
+	}
+	return id, nil
+}
+
+func (StringCodec) Generate() interface{} {
+	return primitive.NewObjectID().Hex()
+}
+
+func (StringCodec) Format(id interface{}) (string, error) {
+	str, ok := id.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid id %v", id)
+	}
+	return str, nil
+}
+
+// Int64Codec does not generate keys, inserted documents must carry their own _id.
+type Int64Codec struct{}
+
+func (Int64Codec) Parse(id string) (interface{}, error) {
+	return strconv.ParseInt(id, 10, 64)
+}
+
+func (Int64Codec) Generate() interface{} {
+	return nil
+}
+
+func (Int64Codec) Format(id interface{}) (string, error) {
+	switch v := id.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	default:
+		return "", fmt.Errorf("invalid id %v", id)
+	}
+}
+
+// CompositeCodec maps "a:b" style ids onto an embedded _id document. Fields
+// without a matching codec are treated as strings.
+type CompositeCodec struct {
+	Fields    []string
+	Codecs    []IDCodec
+	Separator string
+}
+
+func (c CompositeCodec) separator() string {
+	if c.Separator == "" {
+		return ":"
+	}
+	return c.Separator
+}
+
+func (c CompositeCodec) fieldCodec(i int) IDCodec {
+	if i < len(c.Codecs) && c.Codecs[i] != nil {
+		return c.Codecs[i]
+	}
+	return StringCodec{}
+}
+
+func (c CompositeCodec) Parse(id string) (interface{}, error) {
+	parts := strings.Split(id, c.separator())
+	if len(parts) != len(c.Fields) {
+		return nil, fmt.Errorf("invalid composite id %s", id)
+	}
+	result := bson.D{}
+	for i, field := range c.Fields {
+		value, err := c.fieldCodec(i).Parse(parts[i])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, bson.E{Key: field, Value: value})
+	}
+	return result, nil
+}
+
+func (c CompositeCodec) Generate() interface{} {
+	return nil
+}
+
+func (c CompositeCodec) Format(id interface{}) (string, error) {
+	values := map[string]interface{}{}
+	switch v := id.(type) {
+	case bson.D:
+		for _, e := range v {
+			values[e.Key] = e.Value
+		}
+	case bson.M:
+		values = v
+	default:
+		return "", fmt.Errorf("invalid composite id %v", id)
+	}
+	parts := make([]string, len(c.Fields))
+	for i, field := range c.Fields {
+		part, err := c.fieldCodec(i).Format(values[field])
+		if err != nil {
+			return "", err
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, c.separator()), nil
+}
@@ -0,0 +1,179 @@
+package querybuilder
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var errIncludeWithoutSchema = errors.New("include requires a schema")
+
+// Relationship declares a reference from LocalField to ForeignField (the _id
+// by default) of documents in Collection. Many relationships hold an array of
+// references.
+type Relationship struct {
+	Collection   string
+	LocalField   string
+	ForeignField string
+	Many         bool
+	IDCodec      IDCodec
+	Schema       *Schema
+}
+
+func (r Relationship) foreignField() string {
+	if r.ForeignField == "" {
+		return "_id"
+	}
+	return r.ForeignField
+}
+
+func (r Relationship) codec() IDCodec {
+	if r.IDCodec == nil {
+		return ObjectIDCodec{}
+	}
+	return r.IDCodec
+}
+
+func (r Relationship) resourceType() string {
+	if r.Schema != nil && r.Schema.Type != "" {
+		return r.Schema.Type
+	}
+	return r.Collection
+}
+
+type includeTree map[string]includeTree
+
+func newIncludeTree(paths []string) includeTree {
+	tree := includeTree{}
+	for _, path := range paths {
+		node := tree
+		for _, name := range strings.Split(path, ".") {
+			if _, ok := node[name]; !ok {
+				node[name] = includeTree{}
+			}
+			node = node[name]
+		}
+	}
+	return tree
+}
+
+func (t includeTree) names() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Schema) validateInclude(path []string) error {
+	rel, ok := s.Relationships[path[0]]
+	if !ok {
+		return &PolicyError{Field: path[0], Action: ActionInclude}
+	}
+	if len(path) == 1 {
+		return nil
+	}
+	if rel.Schema == nil {
+		return &PolicyError{Field: strings.Join(path, "."), Action: ActionInclude}
+	}
+	return rel.Schema.validateInclude(path[1:])
+}
+
+// lookupStages compiles the include tree into $lookup stages. Related
+// documents are restricted by scope and projected with the sparse fieldset of
+// their type, or with the hidden fields of their schema removed.
+func (s *Schema) lookupStages(ctx context.Context, tree includeTree, fieldSets map[string][]string, scope func(context.Context, bson.D) (bson.D, error)) ([]bson.D, error) {
+	var stages []bson.D
+	for _, name := range tree.names() {
+		rel, ok := s.Relationships[name]
+		if !ok {
+			return nil, &PolicyError{Field: name, Action: ActionInclude}
+		}
+		local := "$$local"
+		foreign := "$" + rel.foreignField()
+		var match bson.D
+		if rel.Many {
+			match = bson.D{{Key: "$in", Value: bson.A{foreign, bson.D{{Key: "$ifNull", Value: bson.A{local, bson.A{}}}}}}}
+		} else {
+			match = bson.D{{Key: "$eq", Value: bson.A{foreign, local}}}
+		}
+		filter, err := scope(ctx, bson.D{{Key: "$expr", Value: match}})
+		if err != nil {
+			return nil, err
+		}
+		pipeline := bson.A{bson.D{{Key: "$match", Value: filter}}}
+		subtree := tree[name]
+		if len(subtree) > 0 {
+			if rel.Schema == nil {
+				return nil, &PolicyError{Field: name, Action: ActionInclude}
+			}
+			nested, err := rel.Schema.lookupStages(ctx, subtree, fieldSets, scope)
+			if err != nil {
+				return nil, err
+			}
+			for _, stage := range nested {
+				pipeline = append(pipeline, stage)
+			}
+		}
+		if rel.Schema != nil {
+			prj, err := rel.Schema.relatedProjection(ctx, subtree, fieldSets)
+			if err != nil {
+				return nil, err
+			}
+			if prj != nil {
+				pipeline = append(pipeline, bson.D{{Key: "$project", Value: prj}})
+			}
+		}
+		stages = append(stages, bson.D{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: rel.Collection},
+			{Key: "let", Value: bson.D{{Key: "local", Value: "$" + rel.LocalField}}},
+			{Key: "pipeline", Value: pipeline},
+			{Key: "as", Value: name},
+		}}})
+		if !rel.Many {
+			stages = append(stages, bson.D{{Key: "$addFields", Value: bson.D{
+				{Key: name, Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{"$" + name, 0}}}},
+			}}})
+		}
+	}
+	return stages, nil
+}
+
+func (s *Schema) relatedProjection(ctx context.Context, tree includeTree, fieldSets map[string][]string) (map[string]int, error) {
+	fields, ok := fieldSets[s.Type]
+	if !ok || s.Type == "" {
+		return s.Projection(ctx, nil), nil
+	}
+	if err := s.Validate(ctx, Options{Fields: fields}); err != nil {
+		return nil, err
+	}
+	prj := map[string]int{}
+	for _, field := range fields {
+		prj[field] = 1
+	}
+	for name, rel := range s.Relationships {
+		if _, ok := tree[name]; ok {
+			prj[name] = 1
+		}
+		if _, ok := prj[name]; ok && rel.LocalField != "" {
+			prj[rel.LocalField] = 1
+		}
+	}
+	return prj, nil
+}
+
+func includeProjection(prj map[string]int, include []string) map[string]int {
+	for _, value := range prj {
+		if value == 0 {
+			return prj
+		}
+	}
+	for name := range newIncludeTree(include) {
+		prj[name] = 1
+	}
+	return prj
+}
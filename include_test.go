@@ -0,0 +1,116 @@
+package querybuilder
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errAggregated = errors.New("aggregated")
+
+type scopeKey struct{}
+
+// pipelineCollection records the pipeline passed to Aggregate.
+type pipelineCollection struct {
+	Collection
+	pipeline mongo.Pipeline
+}
+
+func (c *pipelineCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	c.pipeline = pipeline.(mongo.Pipeline)
+	return nil, errAggregated
+}
+
+func includeSchema() *Schema {
+	return &Schema{
+		Type:   "books",
+		Fields: map[string]Field{"title": {Type: "string", Filterable: true, Selectable: true}},
+		Relationships: map[string]Relationship{
+			"author": {Collection: "authors", LocalField: "authorId", Schema: &Schema{
+				Type:          "authors",
+				Fields:        map[string]Field{"name": {Type: "string", Selectable: true}},
+				Relationships: map[string]Relationship{"publisher": {Collection: "publishers", LocalField: "publisherId"}},
+			}},
+		},
+	}
+}
+
+// lookupMatches returns the $match of every $lookup sub-pipeline, nested ones
+// included.
+func lookupMatches(stages []bson.D) []interface{} {
+	var matches []interface{}
+	for _, stage := range stages {
+		if stage[0].Key != "$lookup" {
+			continue
+		}
+		for _, field := range stage[0].Value.(bson.D) {
+			if field.Key != "pipeline" {
+				continue
+			}
+			var sub []bson.D
+			for _, s := range field.Value.(bson.A) {
+				sub = append(sub, s.(bson.D))
+			}
+			matches = append(matches, sub[0][0].Value)
+			matches = append(matches, lookupMatches(sub)...)
+		}
+	}
+	return matches
+}
+
+func TestIncludeScope(t *testing.T) {
+	ctx := context.WithValue(context.Background(), scopeKey{}, "t1")
+	tests := []struct {
+		name    string
+		find    func(rb *ReadBuilder) error
+		lookups int
+	}{
+		{
+			name: "find",
+			find: func(rb *ReadBuilder) error {
+				_, err := rb.FindContext(ctx, "include=author.publisher")
+				return err
+			},
+			lookups: 2,
+		},
+		{
+			name: "search",
+			find: func(rb *ReadBuilder) error {
+				_, err := rb.SearchContext(ctx, "include=author")
+				return err
+			},
+			lookups: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection := &pipelineCollection{}
+			rb := NewSearchBuilder(collection)
+			rb.SetSchema(includeSchema())
+			rb.SetScope(ContextScope("tenantId", scopeKey{}))
+			if err := tt.find(rb); !errors.Is(err, errAggregated) {
+				t.Fatalf("got %v, want the include to run as an aggregation", err)
+			}
+			var stages []bson.D
+			for _, stage := range collection.pipeline {
+				stages = append(stages, stage)
+			}
+			matches := lookupMatches(stages)
+			if len(matches) != tt.lookups {
+				t.Fatalf("got %d lookups, want %d", len(matches), tt.lookups)
+			}
+			for _, match := range matches {
+				clauses := match.(bson.D)[0].Value.(bson.A)
+				want := bson.D{{Key: "tenantId", Value: "t1"}}
+				if !reflect.DeepEqual(clauses[1], want) {
+					t.Errorf("lookup $match %v is not scoped", match)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package querybuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const JSONAPIMediaType = "application/vnd.api+json"
+
+type JSONAPIDocument struct {
+	Data     interface{}       `json:"data,omitempty"`
+	Errors   []JSONAPIError    `json:"errors,omitempty"`
+	Meta     interface{}       `json:"meta,omitempty"`
+	Links    map[string]string `json:"links,omitempty"`
+	Included []JSONAPIResource `json:"included,omitempty"`
+}
+
+type JSONAPIResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id,omitempty"`
+	Attributes    map[string]interface{}         `json:"attributes,omitempty"`
+	Relationships map[string]JSONAPIRelationship `json:"relationships,omitempty"`
+}
+
+type JSONAPIIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type JSONAPIRelationship struct {
+	Data interface{} `json:"data"`
+}
+
+type JSONAPIError struct {
+	Status string              `json:"status,omitempty"`
+	Code   string              `json:"code,omitempty"`
+	Title  string              `json:"title,omitempty"`
+	Detail string              `json:"detail,omitempty"`
+	Source *JSONAPIErrorSource `json:"source,omitempty"`
+}
+
+type JSONAPIErrorSource struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// NewJSONAPIResource converts a stored document into a resource object. When
+// fields is not empty only the attributes it selects are kept.
+func NewJSONAPIResource(typ string, doc bson.M, codec IDCodec, fields []string) (JSONAPIResource, error) {
+	resource := JSONAPIResource{Type: typ, Attributes: map[string]interface{}{}}
+	if id, ok := doc["_id"]; ok {
+		formatted, err := codec.Format(id)
+		if err != nil {
+			return resource, err
+		}
+		resource.ID = formatted
+	}
+	for key, value := range doc {
+		if key == "_id" {
+			continue
+		}
+		if !fieldSelected(fields, key) {
+			continue
+		}
+		resource.Attributes[key] = value
+	}
+	return resource, nil
+}
+
+// fieldSelected reports whether the sparse fieldset keeps key. Names prefixed
+// with - are dropped, and the others, when there are any, are the only ones
+// kept.
+func fieldSelected(fields []string, key string) bool {
+	included := false
+	for _, field := range fields {
+		if strings.HasPrefix(field, "-") {
+			if field[1:] == key {
+				return false
+			}
+			continue
+		}
+		included = true
+	}
+	return !included || contains(fields, key, true)
+}
+
+func NewJSONAPIErrors(status int, err error) JSONAPIDocument {
+	obj := JSONAPIError{
+		Status: strconv.Itoa(status),
+		Title:  http.StatusText(status),
+	}
+	if err != nil {
+		obj.Detail = err.Error()
+	}
+	var (
+		policyErr *PolicyError
+		limitErr  *LimitError
+	)
+	switch {
+	case errors.As(err, &policyErr):
+		obj.Code = "invalid_" + policyErr.Action
+		obj.Source = &JSONAPIErrorSource{Parameter: policyParameter(policyErr)}
+	case errors.As(err, &limitErr):
+		obj.Code = "limit_exceeded"
+	}
+	return JSONAPIDocument{Errors: []JSONAPIError{obj}}
+}
+
+func policyParameter(err *PolicyError) string {
+	switch err.Action {
+	case ActionFilter:
+		return "filter[" + strings.ReplaceAll(err.Field, ".", "][") + "]"
+	case ActionSort:
+		return "sort"
+	default:
+		return "fields"
+	}
+}
+
+func JSONAPILinks(links []Link) map[string]string {
+	result := map[string]string{}
+	for _, link := range links {
+		result[link.Rel] = link.Href
+	}
+	return result
+}
+
+func WriteJSONAPI(w http.ResponseWriter, status int, doc JSONAPIDocument) {
+	w.Header().Set("Content-Type", JSONAPIMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// JSONAPIResource converts a stored document using the schema relationships:
+// reference fields become relationship identifiers and documents joined by
+// include are returned as included resources.
+func (s *Schema) JSONAPIResource(doc bson.M, codec IDCodec, fields []string) (JSONAPIResource, []JSONAPIResource, error) {
+	resource, err := NewJSONAPIResource(s.Type, doc, codec, fields)
+	if err != nil {
+		return resource, nil, err
+	}
+	var included []JSONAPIResource
+	names := make([]string, 0, len(s.Relationships))
+	for name := range s.Relationships {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rel := s.Relationships[name]
+		delete(resource.Attributes, name)
+		delete(resource.Attributes, rel.LocalField)
+		if !fieldSelected(fields, name) {
+			continue
+		}
+		var identifiers []JSONAPIIdentifier
+		if related, ok := doc[name]; ok {
+			for _, relatedDoc := range relatedDocuments(related) {
+				var (
+					item   JSONAPIResource
+					nested []JSONAPIResource
+				)
+				if rel.Schema != nil {
+					item, nested, err = rel.Schema.JSONAPIResource(relatedDoc, rel.codec(), nil)
+				} else {
+					item, err = NewJSONAPIResource(rel.resourceType(), relatedDoc, rel.codec(), nil)
+				}
+				if err != nil {
+					return resource, nil, err
+				}
+				identifiers = append(identifiers, JSONAPIIdentifier{Type: item.Type, ID: item.ID})
+				included = append(included, item)
+				included = append(included, nested...)
+			}
+		} else if local, ok := doc[rel.LocalField]; ok {
+			for _, id := range relatedIDs(local) {
+				formatted, err := rel.codec().Format(id)
+				if err != nil {
+					return resource, nil, err
+				}
+				identifiers = append(identifiers, JSONAPIIdentifier{Type: rel.resourceType(), ID: formatted})
+			}
+		} else {
+			continue
+		}
+		if resource.Relationships == nil {
+			resource.Relationships = map[string]JSONAPIRelationship{}
+		}
+		if rel.Many {
+			if identifiers == nil {
+				identifiers = []JSONAPIIdentifier{}
+			}
+			resource.Relationships[name] = JSONAPIRelationship{Data: identifiers}
+		} else if len(identifiers) > 0 {
+			resource.Relationships[name] = JSONAPIRelationship{Data: identifiers[0]}
+		} else {
+			resource.Relationships[name] = JSONAPIRelationship{Data: nil}
+		}
+	}
+	return resource, included, nil
+}
+
+func relatedDocuments(value interface{}) []bson.M {
+	switch v := value.(type) {
+	case bson.M:
+		return []bson.M{v}
+	case bson.A:
+		return relatedDocuments([]interface{}(v))
+	case []interface{}:
+		var docs []bson.M
+		for _, item := range v {
+			if doc, ok := item.(bson.M); ok {
+				docs = append(docs, doc)
+			}
+		}
+		return docs
+	default:
+		return nil
+	}
+}
+
+func relatedIDs(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bson.A:
+		return v
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+func uniqueResources(resources []JSONAPIResource) []JSONAPIResource {
+	seen := map[JSONAPIIdentifier]bool{}
+	var unique []JSONAPIResource
+	for _, resource := range resources {
+		key := JSONAPIIdentifier{Type: resource.Type, ID: resource.ID}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, resource)
+	}
+	return unique
+}
@@ -0,0 +1,50 @@
+package querybuilder
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestJSONAPIResourceFields(t *testing.T) {
+	schema := &Schema{
+		Type: "users",
+		Relationships: map[string]Relationship{
+			"team": {Collection: "teams", LocalField: "teamId", IDCodec: StringCodec{}},
+		},
+	}
+	doc := bson.M{"_id": "u1", "name": "john", "email": "j@x", "secret": "s", "teamId": "t1"}
+	tests := []struct {
+		name          string
+		fields        []string
+		want          []string
+		relationships bool
+	}{
+		{name: "all", want: []string{"email", "name", "secret"}, relationships: true},
+		{name: "include", fields: []string{"name", "team"}, want: []string{"name"}, relationships: true},
+		{name: "exclude", fields: []string{"-secret"}, want: []string{"email", "name"}, relationships: true},
+		{name: "exclude relationship", fields: []string{"-team", "-email"}, want: []string{"name", "secret"}},
+		{name: "mixed", fields: []string{"name", "secret", "-secret"}, want: []string{"name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource, _, err := schema.JSONAPIResource(doc, StringCodec{}, tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for key := range resource.Attributes {
+				got = append(got, key)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got attributes %v, want %v", got, tt.want)
+			}
+			if _, ok := resource.Relationships["team"]; ok != tt.relationships {
+				t.Errorf("team relationship present: %t, want %t", ok, tt.relationships)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package querybuilder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits bounds the size of a parsed query. Zero values disable the matching
+// check; negative page values are rejected regardless.
+type Limits struct {
+	MaxParams       int
+	MaxInValues     int
+	MaxDepth        int
+	MaxPage         int
+	MaxPageSize     int
+	DefaultPageSize int
+	MaxSortKeys     int
+	MaxRegexLength  int
+	MaxIncludeDepth int
+}
+
+type LimitError struct {
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("query exceeds the %s limit of %d", e.Limit, e.Max)
+}
+
+func FromQueryStringWithLimits(qs string, limits Limits) (Options, error) {
+	if limits.MaxParams > 0 && strings.Count(qs, "&")+1 > limits.MaxParams {
+		return Options{}, &LimitError{Limit: "params", Max: limits.MaxParams}
+	}
+	if err := limits.checkTerms(qs); err != nil {
+		return Options{}, err
+	}
+	opt, err := FromQueryString(qs)
+	if err != nil {
+		return opt, err
+	}
+	if err := limits.check(&opt); err != nil {
+		return opt, err
+	}
+	return opt, nil
+}
+
+// checkTerms runs before parsing so that deep paths and large array indexes
+// are rejected before any structure is allocated for them.
+func (l Limits) checkTerms(qs string) error {
+	params, err := queryParams(qs)
+	if err != nil {
+		return err
+	}
+	maxIndex := l.MaxInValues
+	if maxIndex == 0 {
+		maxIndex = l.MaxParams
+	}
+	if maxIndex == 0 || maxIndex > maxArrayIndex {
+		maxIndex = maxArrayIndex
+	}
+	for _, param := range params {
+		term := bracketRE.FindStringSubmatch(param[0])
+		if term == nil {
+			continue
+		}
+		keys := strings.Split(term[2], "][")
+		if l.MaxDepth > 0 && len(keys) > l.MaxDepth {
+			return &LimitError{Limit: "depth", Max: l.MaxDepth}
+		}
+		for _, key := range keys {
+			if index, err := strconv.Atoi(key); err == nil && index >= maxIndex {
+				return &LimitError{Limit: "array index", Max: maxIndex}
+			}
+		}
+	}
+	return nil
+}
+
+func (l Limits) check(opt *Options) error {
+	if l.MaxSortKeys > 0 && len(opt.Sort) > l.MaxSortKeys {
+		return &LimitError{Limit: "sort keys", Max: l.MaxSortKeys}
+	}
+	if l.MaxIncludeDepth > 0 {
+		for _, path := range opt.Include {
+			if strings.Count(path, ".")+1 > l.MaxIncludeDepth {
+				return &LimitError{Limit: "include depth", Max: l.MaxIncludeDepth}
+			}
+		}
+	}
+	for _, key := range sortedKeys(opt.Page) {
+		if opt.Page[key] < 0 {
+			return fmt.Errorf("%w: page[%s] must not be negative", ErrInvalidQuery, key)
+		}
+	}
+	if l.MaxPage > 0 && opt.Page["page"] > l.MaxPage {
+		return &LimitError{Limit: "page", Max: l.MaxPage}
+	}
+	if l.MaxPageSize > 0 {
+		for _, key := range []string{"size", "limit"} {
+			if value, ok := opt.Page[key]; ok && value > l.MaxPageSize {
+				return &LimitError{Limit: "page " + key, Max: l.MaxPageSize}
+			}
+		}
+	}
+	if l.DefaultPageSize > 0 {
+		_, hasSize := opt.Page["size"]
+		_, hasLimit := opt.Page["limit"]
+		if !hasSize && !hasLimit {
+			if opt.Page == nil {
+				opt.Page = map[string]int{}
+			}
+			opt.Page["size"] = l.DefaultPageSize
+			opt.SetPaginationStrategy(&PageSizeStrategy{})
+		}
+	}
+	for _, term := range filterTerms(opt.Filter) {
+		if l.MaxInValues > 0 && len(term.Values) > l.MaxInValues {
+			return &LimitError{Limit: "in values", Max: l.MaxInValues}
+		}
+		if l.MaxRegexLength == 0 {
+			continue
+		}
+		if term.Operator != "$like" && term.Operator != "$regex" {
+			continue
+		}
+		for _, value := range term.Values {
+			if len(trimOperatorPrefix(fmt.Sprint(value))) > l.MaxRegexLength {
+				return &LimitError{Limit: "regex length", Max: l.MaxRegexLength}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package querybuilder
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLimitsPage(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    Limits
+		query     string
+		wantLimit string
+		invalid   bool
+	}{
+		{name: "no limits", query: "page[page]=100000&page[size]=1000"},
+		{name: "negative page", query: "page[page]=-1&page[size]=10", invalid: true},
+		{name: "negative size", query: "page[size]=-10", invalid: true},
+		{name: "negative limit", query: "page[limit]=-1", invalid: true},
+		{name: "negative offset", query: "page[limit]=10&page[offset]=-5", invalid: true},
+		{name: "negative skip", query: "page[limit]=10&page[skip]=-5", invalid: true},
+		{name: "negative with limits", limits: Limits{MaxPageSize: 50}, query: "page[size]=-1", invalid: true},
+		{name: "page within bound", limits: Limits{MaxPage: 10}, query: "page[page]=10&page[size]=5"},
+		{name: "page beyond bound", limits: Limits{MaxPage: 10}, query: "page[page]=11&page[size]=5", wantLimit: "page"},
+		{name: "size beyond bound", limits: Limits{MaxPageSize: 50}, query: "page[size]=51", wantLimit: "page size"},
+		{name: "limit beyond bound", limits: Limits{MaxPageSize: 50}, query: "page[limit]=51", wantLimit: "page limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromQueryStringWithLimits(tt.query, tt.limits)
+			var limitErr *LimitError
+			switch {
+			case tt.invalid:
+				if !errors.Is(err, ErrInvalidQuery) {
+					t.Fatalf("got %v, want ErrInvalidQuery", err)
+				}
+			case tt.wantLimit != "":
+				if !errors.As(err, &limitErr) || limitErr.Limit != tt.wantLimit {
+					t.Fatalf("got %v, want the %s limit", err, tt.wantLimit)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestLimitsFilterPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    Limits
+		query     string
+		wantLimit string
+		invalid   bool
+	}{
+		{name: "or branches", query: "filter[$or][0][a]=1&filter[$or][1][b]=2"},
+		{name: "negative index", query: "filter[$or][-1][a]=1", invalid: true},
+		{name: "value then map", query: "filter[$or][0]=x&filter[$or][0][a]=1", invalid: true},
+		{name: "map then value", query: "filter[$or][0][a]=1&filter[$or][0]=x", invalid: true},
+		{name: "or as value", query: "filter[$or]=x&filter[$or][0][a]=1", invalid: true},
+		{name: "or as value after branches", query: "filter[$or][0][a]=1&filter[$or]=x", invalid: true},
+		{name: "array then map", query: "filter[$or][0][a]=1&filter[$or][a]=1", invalid: true},
+		{name: "nested value then map", query: "filter[$or][0][a]=1&filter[$or][0][a][b]=1", invalid: true},
+		{name: "index without limits", query: "filter[$or][100][a]=1", wantLimit: "array index"},
+		{name: "index within limits", limits: Limits{MaxInValues: 5}, query: "filter[$or][4][a]=1"},
+		{name: "index beyond limits", limits: Limits{MaxInValues: 5}, query: "filter[$or][5][a]=1", wantLimit: "array index"},
+		{name: "index beyond default with high limits", limits: Limits{MaxParams: 1000}, query: "filter[$or][500][a]=1", wantLimit: "array index"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromQueryStringWithLimits(tt.query, tt.limits)
+			var limitErr *LimitError
+			switch {
+			case tt.invalid:
+				if !errors.Is(err, ErrInvalidQuery) {
+					t.Fatalf("got %v, want ErrInvalidQuery", err)
+				}
+			case tt.wantLimit != "":
+				if !errors.As(err, &limitErr) || limitErr.Limit != tt.wantLimit {
+					t.Fatalf("got %v, want the %s limit", err, tt.wantLimit)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.limits != (Limits{}) {
+				return
+			}
+			if _, err := FromQueryString(tt.query); (err != nil) != (tt.invalid || tt.wantLimit != "") {
+				t.Errorf("FromQueryString got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package querybuilder
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type Link struct {
+	Rel  string
+	Href string
+}
+
+// Links returns the self, first, prev, next and last links for the page
+// described by opt, relative to the builder route.
+func (c *PaginationBuilder) Links(opt Options, total int64) []Link {
+	links := []Link{{Rel: "self", Href: joinRoute(c.route, opt.Encode())}}
+	if opt.ps == nil || len(opt.Page) == 0 {
+		return links
+	}
+	size := opt.Page["size"]
+	page := opt.Page["page"]
+	links = append(links, Link{Rel: "first", Href: joinRoute(c.route, opt.First())})
+	if page > 0 {
+		links = append(links, Link{Rel: "prev", Href: joinRoute(c.route, opt.Prev())})
+	}
+	if size > 0 && int64((page+1)*size) < total {
+		links = append(links, Link{Rel: "next", Href: joinRoute(c.route, opt.Next())})
+	}
+	links = append(links, Link{Rel: "last", Href: joinRoute(c.route, opt.Last(int(total)))})
+	return links
+}
+
+// LinkHeader formats Links as an RFC 8288 Link header value.
+func (c *PaginationBuilder) LinkHeader(opt Options, total int64) string {
+	var values []string
+	for _, link := range c.Links(opt, total) {
+		values = append(values, fmt.Sprintf(`<%s>; rel="%s"`, link.Href, link.Rel))
+	}
+	return strings.Join(values, ", ")
+}
+
+func (c *PaginationBuilder) WriteHeaders(w http.ResponseWriter, opt Options, total int64) {
+	w.Header().Set("Link", c.LinkHeader(opt, total))
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+}
+
+func joinRoute(route string, qs string) string {
+	if qs == "" {
+		return route
+	}
+	sep := "?"
+	if strings.Contains(route, "?") {
+		sep = "&"
+	}
+	return route + sep + qs
+}
@@ -0,0 +1,56 @@
+package querybuilder_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/walkaba/querybuilder"
+	"github.com/walkaba/querybuilder/querybuildertest"
+)
+
+func TestLinkHeaderEscaping(t *testing.T) {
+	collection, err := querybuildertest.NewCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := querybuilder.NewPaginationBuilder(collection, "/users")
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"angle brackets", "filter[name]=<b>&page[page]=1&page[size]=10"},
+		{"separators", `filter[name]=a, b; c="d"&page[page]=1&page[size]=10`},
+		{"key", "filter[na<me>]=x&sort=-name&page[page]=0&page[size]=10"},
+		{"percent", "filter[name]=100%25&page[page]=1&page[size]=10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := querybuilder.FromQueryString(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			links := pb.Links(opt, 100)
+			if len(links) < 2 {
+				t.Fatalf("got %d links, want paging links", len(links))
+			}
+			for _, link := range links {
+				if strings.ContainsAny(link.Href, `<>" ;`) {
+					t.Errorf("%s link %q is not escaped", link.Rel, link.Href)
+				}
+				qs := strings.TrimPrefix(link.Href, "/users?")
+				parsed, err := querybuilder.FromQueryString(qs)
+				if err != nil {
+					t.Fatalf("%s link %q does not parse: %v", link.Rel, link.Href, err)
+				}
+				if !reflect.DeepEqual(parsed.Filter, opt.Filter) {
+					t.Errorf("%s link filter = %v, want %v", link.Rel, parsed.Filter, opt.Filter)
+				}
+			}
+			header := pb.LinkHeader(opt, 100)
+			if got := strings.Count(header, "<"); got != len(links) {
+				t.Errorf("header %q has %d targets, want %d", header, got, len(links))
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+package querybuilder
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Evaluator applies parsed Options to documents in memory. Like
+// MongoCompiler it parses _id values with IDCodec, ObjectIDCodec by default,
+// and converts values to the field types declared by Schema.
+type Evaluator struct {
+	IDCodec IDCodec
+	Schema  *Schema
+}
+
+// Evaluate applies opt with the default Evaluator.
+func Evaluate(opt Options, docs interface{}) ([]bson.M, error) {
+	return Evaluator{}.Evaluate(opt, docs)
+}
+
+// Evaluate applies the filter, sort, pagination and projection of opt to an
+// in-memory slice of maps or structs, following the semantics of the Mongo
+// compiler. Structs are converted with their bson tags.
+func (e Evaluator) Evaluate(opt Options, docs interface{}) ([]bson.M, error) {
+	all, err := toDocuments(docs)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := ParseFilter(opt.Filter)
+	if err != nil {
+		return nil, err
+	}
+	compiler := MongoCompiler{IDCodec: e.IDCodec}
+	if e.Schema != nil {
+		compiler.FieldTypes = e.Schema.fieldTypes()
+	}
+	expr, err = compileValues(compiler, expr)
+	if err != nil {
+		return nil, err
+	}
+	var matched []bson.M
+	for _, doc := range all {
+		if MatchDocument(expr, doc) {
+			matched = append(matched, doc)
+		}
+	}
+	SortDocuments(matched, opt.Sort)
+	matched = paginateDocuments(matched, opt.Page)
+	result := make([]bson.M, len(matched))
+	for i, doc := range matched {
+		result[i] = projectDocument(doc, opt.Fields)
+	}
+	return result, nil
+}
+
+func toDocuments(docs interface{}) ([]bson.M, error) {
+	v := reflect.ValueOf(docs)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected a slice, got %T", docs)
+	}
+	result := make([]bson.M, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i).Interface()
+		switch doc := item.(type) {
+		case bson.M:
+			result[i] = doc
+		case map[string]interface{}:
+			result[i] = doc
+		default:
+			raw, err := bson.Marshal(item)
+			if err != nil {
+				return nil, err
+			}
+			m := bson.M{}
+			if err := bson.Unmarshal(raw, &m); err != nil {
+				return nil, err
+			}
+			result[i] = m
+		}
+	}
+	return result, nil
+}
+
+// compileValues converts the values of expr as the Mongo compiler does when
+// it compiles them.
+func compileValues(compiler MongoCompiler, expr Expr) (Expr, error) {
+	var err error
+	switch x := expr.(type) {
+	case And:
+		exprs := make([]Expr, len(x.Exprs))
+		for i, child := range x.Exprs {
+			if exprs[i], err = compileValues(compiler, child); err != nil {
+				return nil, err
+			}
+		}
+		return And{Exprs: exprs}, nil
+	case Or:
+		exprs := make([]Expr, len(x.Exprs))
+		for i, child := range x.Exprs {
+			if exprs[i], err = compileValues(compiler, child); err != nil {
+				return nil, err
+			}
+		}
+		return Or{Exprs: exprs}, nil
+	case Not:
+		x.Expr, err = compileValues(compiler, x.Expr)
+		return x, err
+	case ElemMatch:
+		x.Expr, err = compileValues(compiler, x.Expr)
+		return x, err
+	case Compare:
+		x.Value, err = compiler.value(x.Field, x.Value)
+		return x, err
+	case In:
+		values := make([]interface{}, len(x.Values))
+		for i, value := range x.Values {
+			if values[i], err = compiler.value(x.Field, value); err != nil {
+				return nil, err
+			}
+		}
+		x.Values = values
+		return x, nil
+	default:
+		return expr, nil
+	}
+}
+
+// MatchDocument reports whether doc matches expr. Values are compared as
+// given, so _id values must already have the stored type.
+func MatchDocument(expr Expr, doc bson.M) bool {
+	switch e := expr.(type) {
+	case nil:
+		return true
+	case And:
+		for _, child := range e.Exprs {
+			if !MatchDocument(child, doc) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for _, child := range e.Exprs {
+			if MatchDocument(child, doc) {
+				return true
+			}
+		}
+		return false
+	case Not:
+		return !MatchDocument(e.Expr, doc)
+	case Compare:
+		values, found := lookupPath(doc, e.Field)
+		if e.Op == Ne {
+			return !matchAny(values, found, func(v interface{}) bool { return compareEqual(v, e.Value) }, e.Value == nil)
+		}
+		if e.Op == Size {
+			for _, v := range values {
+				if list, ok := asArray(v); ok && len(list) == e.Value {
+					return true
+				}
+			}
+			return false
+		}
+		return matchAny(values, found, func(v interface{}) bool { return compareOp(v, e.Op, e.Value) }, e.Op == Eq && e.Value == nil)
+	case In:
+		values, found := lookupPath(doc, e.Field)
+		for _, want := range e.Values {
+			if matchAny(values, found, func(v interface{}) bool { return compareEqual(v, want) }, want == nil) {
+				return true
+			}
+		}
+		return false
+	case Exists:
+		_, found := lookupPath(doc, e.Field)
+		return found == e.Exists
+	case Match:
+		re, err := regexp.Compile("(?mi)" + e.Pattern)
+		if err != nil {
+			return false
+		}
+		values, found := lookupPath(doc, e.Field)
+		return matchAny(values, found, func(v interface{}) bool {
+			str, ok := v.(string)
+			return ok && re.MatchString(str)
+		}, false)
+	case ElemMatch:
+		values, _ := lookupPath(doc, e.Field)
+		inner := prefixFields("element", e.Expr)
+		for _, v := range values {
+			list, ok := asArray(v)
+			if !ok {
+				continue
+			}
+			for _, item := range list {
+				if MatchDocument(inner, bson.M{"element": item}) {
+					return true
+				}
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// lookupPath resolves a dotted path, descending into arrays the way Mongo
+// does, and reports whether the path exists at all.
+func lookupPath(value interface{}, path string) ([]interface{}, bool) {
+	if path == "" {
+		return []interface{}{value}, true
+	}
+	key, rest, _ := strings.Cut(path, ".")
+	switch v := value.(type) {
+	case bson.M:
+		child, ok := v[key]
+		if !ok {
+			return nil, false
+		}
+		return lookupPath(child, rest)
+	case map[string]interface{}:
+		return lookupPath(bson.M(v), path)
+	case bson.D:
+		return lookupPath(v.Map(), path)
+	}
+	list, ok := asArray(value)
+	if !ok {
+		return nil, false
+	}
+	var (
+		values []interface{}
+		found  bool
+	)
+	for _, item := range list {
+		if _, ok := asArray(item); ok {
+			continue
+		}
+		vals, ok := lookupPath(item, path)
+		values = append(values, vals...)
+		found = found || ok
+	}
+	return values, found
+}
+
+// asArray returns value as a bson.A when it is a slice or array of any
+// element type other than bytes, which are binary values and ObjectIDs.
+func asArray(value interface{}) (bson.A, bool) {
+	switch v := value.(type) {
+	case bson.A:
+		return v, true
+	case []interface{}:
+		return bson.A(v), true
+	case nil:
+		return nil, false
+	}
+	rv := reflect.ValueOf(value)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list := make(bson.A, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+// matchAny reports whether pred holds for a value or, for arrays, for one of
+// their elements. Missing fields match only null equality.
+func matchAny(values []interface{}, found bool, pred func(interface{}) bool, matchMissing bool) bool {
+	if !found {
+		return matchMissing
+	}
+	for _, v := range values {
+		if pred(v) {
+			return true
+		}
+		if list, ok := asArray(v); ok {
+			for _, item := range list {
+				if pred(item) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func compareEqual(a, b interface{}) bool {
+	return typeRank(normalizeValue(a)) == typeRank(normalizeValue(b)) && compareValues(a, b) == 0
+}
+
+func compareOp(value interface{}, op Operator, want interface{}) bool {
+	if op == Eq {
+		return compareEqual(value, want)
+	}
+	if typeRank(normalizeValue(value)) != typeRank(normalizeValue(want)) {
+		return false
+	}
+	c := compareValues(value, want)
+	switch op {
+	case Gt:
+		return c > 0
+	case Gte:
+		return c >= 0
+	case Lt:
+		return c < 0
+	case Lte:
+		return c <= 0
+	default:
+		return false
+	}
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case primitive.DateTime:
+		return v.Time()
+	case map[string]interface{}:
+		return bson.M(v)
+	default:
+		if list, ok := asArray(v); ok {
+			return list
+		}
+		return v
+	}
+}
+
+// typeRank follows the Mongo sort order of BSON types.
+func typeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	case bson.M, bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	default:
+		return 10
+	}
+}
+
+func compareValues(a, b interface{}) int {
+	a, b = normalizeValue(a), normalizeValue(b)
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch x := a.(type) {
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case primitive.ObjectID:
+		return strings.Compare(x.Hex(), b.(primitive.ObjectID).Hex())
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case time.Time:
+		return x.Compare(b.(time.Time))
+	case nil:
+		return 0
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+func SortDocuments(docs []bson.M, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range fields {
+			dir := 1
+			if strings.HasPrefix(field, "-") {
+				dir = -1
+			}
+			name := strings.TrimLeft(field, "+-")
+			a, _ := lookupPath(docs[i], name)
+			b, _ := lookupPath(docs[j], name)
+			if c := compareValues(firstValue(a), firstValue(b)); c != 0 {
+				return c*dir < 0
+			}
+		}
+		return false
+	})
+}
+
+func firstValue(values []interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+func paginateDocuments(docs []bson.M, pagination map[string]int) []bson.M {
+	skip, limit := 0, -1
+	if l, ok := pagination["limit"]; ok {
+		limit = l
+		if offset, ok := pagination["offset"]; ok {
+			skip = offset
+		}
+		if s, ok := pagination["skip"]; ok {
+			skip = s
+		}
+	}
+	if size, ok := pagination["size"]; ok {
+		limit = size
+		skip = pagination["page"] * size
+	}
+	skip = max(0, min(skip, len(docs)))
+	docs = docs[skip:]
+	if limit > 0 && limit < len(docs) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+func projectDocument(doc bson.M, fields []string) bson.M {
+	if len(fields) == 0 {
+		return doc
+	}
+	var include, exclude []string
+	for _, field := range fields {
+		if strings.HasPrefix(field, "-") {
+			exclude = append(exclude, field[1:])
+			continue
+		}
+		include = append(include, strings.TrimPrefix(field, "+"))
+	}
+	result := bson.M{}
+	if len(include) == 0 {
+		for key, value := range doc {
+			result[key] = value
+		}
+	} else {
+		if id, ok := doc["_id"]; ok {
+			result["_id"] = id
+		}
+		for _, path := range include {
+			copyPath(doc, result, path)
+		}
+	}
+	for _, path := range exclude {
+		removePath(result, path)
+	}
+	return result
+}
+
+// copyPath and removePath handle dotted paths through embedded documents.
+// removePath copies the documents it descends into, so doc is never changed.
+func copyPath(src bson.M, dst bson.M, path string) {
+	key, rest, nested := strings.Cut(path, ".")
+	value, ok := src[key]
+	if !ok {
+		return
+	}
+	if !nested {
+		dst[key] = value
+		return
+	}
+	child := embeddedDocument(value)
+	if child == nil {
+		return
+	}
+	sub, ok := dst[key].(bson.M)
+	if !ok {
+		sub = bson.M{}
+		dst[key] = sub
+	}
+	copyPath(child, sub, rest)
+}
+
+func removePath(m bson.M, path string) {
+	key, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		delete(m, key)
+		return
+	}
+	child := embeddedDocument(m[key])
+	if child == nil {
+		return
+	}
+	copied := make(bson.M, len(child))
+	for k, v := range child {
+		copied[k] = v
+	}
+	m[key] = copied
+	removePath(copied, rest)
+}
+
+func embeddedDocument(value interface{}) bson.M {
+	switch v := value.(type) {
+	case bson.M:
+		return v
+	case map[string]interface{}:
+		return bson.M(v)
+	case bson.D:
+		return v.Map()
+	}
+	return nil
+}
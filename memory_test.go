@@ -0,0 +1,139 @@
+package querybuilder
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMatchDocument(t *testing.T) {
+	doc := bson.M{
+		"name": "john",
+		"tags": bson.A{
+			bson.M{"name": "go", "level": 3},
+			bson.M{"name": "rust", "level": 1},
+		},
+		"scores": bson.A{70, 85},
+		"empty":  bson.A{},
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"element", "filter[tags][name]=go", true},
+		{"no element", "filter[tags][name]=java", false},
+		{"element list", "filter[tags][name]=java,rust", true},
+		{"element or empty", "filter[empty][name]=go,null", true},
+		{"element operator", "filter[$or][0][tags][level][$gt]=2", true},
+		{"element operator no match", "filter[$or][0][tags][level][$gt]=5", false},
+		{"string against number", "filter[tags][level][$gt]=2", false},
+		{"same element", "filter[$or][0][tags][name]=rust&filter[$or][0][tags][level]=3", false},
+		{"not an array", "filter[name][first]=john", false},
+		{"scalar", "filter[$or][0][scores][$gte]=80", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := FromQueryString(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expr, err := ParseFilter(opt.Filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := MatchDocument(expr, doc); got != tt.want {
+				t.Errorf("MatchDocument(%#v) = %v, want %v", expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchDocumentGoSlices(t *testing.T) {
+	doc := bson.M{
+		"tags":   []string{"go", "rust"},
+		"scores": []int{70, 85},
+		"items":  []interface{}{map[string]interface{}{"sku": "a"}, bson.M{"sku": "b"}},
+		"nested": map[string]interface{}{"labels": []string{"x"}},
+	}
+	tests := []struct {
+		name string
+		expr Expr
+		want bool
+	}{
+		{"string slice", Compare{Field: "tags", Op: Eq, Value: "go"}, true},
+		{"string slice miss", Compare{Field: "tags", Op: Eq, Value: "java"}, false},
+		{"string slice in", In{Field: "tags", Values: []interface{}{"java", "rust"}}, true},
+		{"string slice size", Compare{Field: "tags", Op: Size, Value: 2}, true},
+		{"int slice", Compare{Field: "scores", Op: Gt, Value: 80}, true},
+		{"interface slice path", Compare{Field: "items.sku", Op: Eq, Value: "b"}, true},
+		{"nested slice", Compare{Field: "nested.labels", Op: Eq, Value: "x"}, true},
+		{"element match", ElemMatch{Field: "items", Expr: Compare{Field: "sku", Op: Eq, Value: "a"}}, true},
+		{"match", Match{Field: "tags", Pattern: "^ru"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchDocument(tt.expr, doc); got != tt.want {
+				t.Errorf("MatchDocument(%#v) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluatePaging(t *testing.T) {
+	docs := []bson.M{{"n": 1}, {"n": 2}, {"n": 3}}
+	tests := []struct {
+		name string
+		page map[string]int
+		want int
+	}{
+		{"none", nil, 3},
+		{"size", map[string]int{"size": 2}, 2},
+		{"last page", map[string]int{"size": 2, "page": 1}, 1},
+		{"beyond", map[string]int{"size": 2, "page": 5}, 0},
+		{"negative page", map[string]int{"size": 2, "page": -1}, 2},
+		{"negative skip", map[string]int{"limit": 2, "skip": -5}, 2},
+		{"negative offset", map[string]int{"limit": 5, "offset": -1}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(Options{Page: tt.page}, docs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d documents, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluatorIDCodec(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name    string
+		codec   IDCodec
+		docs    []bson.M
+		filter  string
+		want    int
+		wantErr bool
+	}{
+		{"object id", nil, []bson.M{{"_id": id}, {"_id": primitive.NewObjectID()}}, id.Hex(), 1, false},
+		{"string id", StringCodec{}, []bson.M{{"_id": id.Hex()}, {"_id": "other"}}, id.Hex(), 1, false},
+		{"string id not parsed as object id", nil, []bson.M{{"_id": id.Hex()}}, id.Hex(), 0, false},
+		{"invalid object id", nil, []bson.M{{"_id": id}}, "nope", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := Options{Filter: map[string]interface{}{"_id": tt.filter}}
+			got, err := Evaluator{IDCodec: tt.codec}.Evaluate(opt, tt.docs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d documents, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package querybuilder
+
+import (
+	"context"
+	"net/http"
+)
+
+type MiddlewareConfig struct {
+	Schema       *Schema
+	Limits       Limits
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+type optionsContextKey struct{}
+
+// Middleware parses and validates the request query once and stores the
+// resulting Options in the request context. Validation is the one the
+// builders run, so the field set of the schema type replaces fields. Hooks
+// are left to the builder that executes the options.
+func Middleware(cfg MiddlewareConfig) func(http.Handler) http.Handler {
+	c := &builderConfig{schema: cfg.Schema, limits: cfg.Limits}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			opt, err := FromQueryStringWithLimits(r.URL.RawQuery, cfg.Limits)
+			if err == nil {
+				err = c.validate(r.Context(), &opt)
+			}
+			if err != nil {
+				if cfg.ErrorHandler != nil {
+					cfg.ErrorHandler(w, r, err)
+					return
+				}
+				WriteProblem(w, problemStatus(err), err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithOptions(r.Context(), opt)))
+		})
+	}
+}
+
+func ContextWithOptions(ctx context.Context, opt Options) context.Context {
+	return context.WithValue(ctx, optionsContextKey{}, opt)
+}
+
+func OptionsFromContext(ctx context.Context) (Options, bool) {
+	opt, ok := ctx.Value(optionsContextKey{}).(Options)
+	return opt, ok
+}
@@ -0,0 +1,85 @@
+package querybuilder_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/walkaba/querybuilder"
+)
+
+func TestMiddleware(t *testing.T) {
+	schema := &querybuilder.Schema{
+		Type: "users",
+		Fields: map[string]querybuilder.Field{
+			"name":   {Type: "string", Filterable: true, Sortable: true, Selectable: true},
+			"email":  {Type: "string", Selectable: true},
+			"secret": {Type: "string"},
+		},
+	}
+	customHandler := func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, "custom: "+err.Error(), http.StatusTeapot)
+	}
+	tests := []struct {
+		name        string
+		query       string
+		limits      querybuilder.Limits
+		handler     func(w http.ResponseWriter, r *http.Request, err error)
+		want        int
+		contentType string
+		wantFields  []string
+	}{
+		{name: "valid", query: "filter[name]=john&fields=name,email", want: http.StatusOK, wantFields: []string{"name", "email"}},
+		{name: "field set of the schema type", query: "fields=secret&fields[users]=email", want: http.StatusOK, wantFields: []string{"email"}},
+		{name: "field set of another type", query: "fields=name&fields[teams]=title", want: http.StatusOK, wantFields: []string{"name"}},
+		{name: "hidden field in field set", query: "fields=name&fields[users]=secret", want: http.StatusBadRequest, contentType: "application/problem+json"},
+		{name: "unfilterable field", query: "filter[email]=a", want: http.StatusBadRequest, contentType: "application/problem+json"},
+		{name: "limit", query: "page[size]=500", limits: querybuilder.Limits{MaxPageSize: 100}, want: http.StatusBadRequest, contentType: "application/problem+json"},
+		{name: "invalid path", query: "filter[$or][-1][name]=a", want: http.StatusBadRequest, contentType: "application/problem+json"},
+		{name: "custom error handler", query: "filter[email]=a", handler: customHandler, want: http.StatusTeapot, contentType: "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				got    querybuilder.Options
+				called bool
+			)
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, called = querybuilder.OptionsFromContext(r.Context())
+			})
+			handler := querybuilder.Middleware(querybuilder.MiddlewareConfig{
+				Schema:       schema,
+				Limits:       tt.limits,
+				ErrorHandler: tt.handler,
+			})(next)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				if called {
+					t.Error("the next handler ran for a rejected query")
+				}
+				if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+					t.Errorf("Content-Type = %q, want %q", ct, tt.contentType)
+				}
+				if tt.contentType == "application/problem+json" {
+					var problem querybuilder.Problem
+					if err := json.NewDecoder(w.Body).Decode(&problem); err != nil || problem.Status != tt.want {
+						t.Errorf("got problem %+v, %v", problem, err)
+					}
+				}
+				return
+			}
+			if !called {
+				t.Fatal("the options are not in the request context")
+			}
+			if !reflect.DeepEqual(got.Fields, tt.wantFields) {
+				t.Errorf("fields = %v, want %v", got.Fields, tt.wantFields)
+			}
+		})
+	}
+}
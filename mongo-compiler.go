@@ -0,0 +1,178 @@
+package querybuilder
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MongoCompiler compiles a filter tree into a Mongo filter. FieldTypes maps
+// field names to their declared Schema type; string values of numeric and
+// boolean fields are converted to that type, as the SQL and Elasticsearch
+// compilers do.
+type MongoCompiler struct {
+	IDCodec    IDCodec
+	FieldTypes map[string]string
+}
+
+func (c MongoCompiler) CompileExpr(expr Expr) (interface{}, error) {
+	return c.CompileFilter(expr)
+}
+
+func (c MongoCompiler) CompileFilter(expr Expr) (bson.D, error) {
+	if expr == nil {
+		return bson.D{}, nil
+	}
+	switch e := expr.(type) {
+	case And:
+		docs := make([]bson.D, 0, len(e.Exprs))
+		for _, child := range e.Exprs {
+			doc, err := c.CompileFilter(child)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+		return mergeFilters(docs), nil
+	case Or:
+		alternatives := bson.A{}
+		for _, child := range e.Exprs {
+			doc, err := c.CompileFilter(child)
+			if err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, doc)
+		}
+		return bson.D{{Key: "$or", Value: alternatives}}, nil
+	case Not:
+		doc, err := c.CompileFilter(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		if len(doc) == 1 {
+			if ops, ok := doc[0].Value.(bson.D); ok && len(ops) > 0 && ops[0].Key[0] == '$' && !strings.HasPrefix(doc[0].Key, "$") {
+				return bson.D{{Key: doc[0].Key, Value: bson.D{{Key: "$not", Value: ops}}}}, nil
+			}
+		}
+		return bson.D{{Key: "$nor", Value: bson.A{doc}}}, nil
+	case Compare:
+		value, err := c.value(e.Field, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: e.Field, Value: bson.D{{Key: string(e.Op), Value: value}}}}, nil
+	case In:
+		values := make([]interface{}, len(e.Values))
+		for i, value := range e.Values {
+			v, err := c.value(e.Field, value)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return bson.D{{Key: e.Field, Value: bson.D{{Key: "$in", Value: values}}}}, nil
+	case Exists:
+		return bson.D{{Key: e.Field, Value: bson.D{{Key: "$exists", Value: e.Exists}}}}, nil
+	case Match:
+		return bson.D{{Key: e.Field, Value: bson.D{
+			{Key: "$regex", Value: e.Pattern},
+			{Key: "$options", Value: "mi"},
+		}}}, nil
+	case ElemMatch:
+		doc, err := c.CompileFilter(e.Expr)
+		if err != nil {
+			return nil, err
+		}
+		var cond interface{} = elementCondition(doc)
+		if len(doc) == 1 && doc[0].Key == "" {
+			cond = doc[0].Value
+		}
+		return bson.D{{Key: e.Field, Value: bson.D{{Key: "$elemMatch", Value: cond}}}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+// elementCondition writes plain equalities of an $elemMatch as values, the
+// form checkFilter produced for tags][name=value.
+func elementCondition(doc bson.D) bson.D {
+	result := make(bson.D, len(doc))
+	for i, e := range doc {
+		if ops, ok := e.Value.(bson.D); ok && len(ops) == 1 && ops[0].Key == "$eq" && !strings.HasPrefix(e.Key, "$") {
+			e.Value = ops[0].Value
+		}
+		result[i] = e
+	}
+	return result
+}
+
+// value parses string values of _id with the codec and converts the others
+// to the declared field type. An id the codec rejects is a client error, it
+// matches both ErrInvalidQuery and ErrInvalidID.
+func (c MongoCompiler) value(field string, value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	if field != "_id" {
+		return coerceValue(c.FieldTypes[field], value), nil
+	}
+	codec := c.IDCodec
+	if codec == nil {
+		codec = ObjectIDCodec{}
+	}
+	id, err := codec.Parse(str)
+	if err != nil {
+		return value, fmt.Errorf("%w: %w: %s", ErrInvalidQuery, ErrInvalidID, str)
+	}
+	return id, nil
+}
+
+// mergeFilters joins the documents of an And. Operators on the same field are
+// combined, anything that would repeat a key falls back to $and.
+func mergeFilters(docs []bson.D) bson.D {
+	merged := bson.D{}
+	index := map[string]int{}
+	for _, doc := range docs {
+		for _, e := range doc {
+			i, ok := index[e.Key]
+			if !ok {
+				index[e.Key] = len(merged)
+				merged = append(merged, e)
+				continue
+			}
+			current, ok1 := merged[i].Value.(bson.D)
+			next, ok2 := e.Value.(bson.D)
+			if !ok1 || !ok2 || !disjointOperators(current, next) {
+				return andFilters(docs)
+			}
+			merged[i].Value = append(append(bson.D{}, current...), next...)
+		}
+	}
+	return merged
+}
+
+func disjointOperators(a, b bson.D) bool {
+	keys := map[string]bool{}
+	for _, e := range a {
+		if e.Key == "" || e.Key[0] != '$' {
+			return false
+		}
+		keys[e.Key] = true
+	}
+	for _, e := range b {
+		if e.Key == "" || e.Key[0] != '$' || keys[e.Key] {
+			return false
+		}
+	}
+	return true
+}
+
+func andFilters(docs []bson.D) bson.D {
+	list := bson.A{}
+	for _, doc := range docs {
+		list = append(list, doc)
+	}
+	return bson.D{{Key: "$and", Value: list}}
+}
@@ -0,0 +1,289 @@
+package querybuilder
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestMongoCompilerGolden compares the compiled filter with the output of
+// the checkFilter based compiler it replaced. want is only set where the
+// output deliberately differs, and change says why.
+func TestMongoCompilerGolden(t *testing.T) {
+	id1, _ := primitive.ObjectIDFromHex("5f1a6b9e8f1b2c3d4e5f6a7b")
+	id2, _ := primitive.ObjectIDFromHex("5f1a6b9e8f1b2c3d4e5f6a7c")
+	tests := []struct {
+		name     string
+		query    string
+		baseline bson.D
+		want     bson.D
+		change   string
+	}{
+		{
+			name:     "equality",
+			query:    "filter[name]=john",
+			baseline: bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "john"}}}},
+		},
+		{
+			name:     "list",
+			query:    "filter[name]=john,jane",
+			baseline: bson.D{{Key: "name", Value: bson.D{{Key: "$in", Value: bson.A{"john", "jane"}}}}},
+		},
+		{
+			name:     "object id",
+			query:    "filter[_id]=5f1a6b9e8f1b2c3d4e5f6a7b",
+			baseline: bson.D{{Key: "_id", Value: bson.D{{Key: "$eq", Value: id1}}}},
+		},
+		{
+			name:     "object id list",
+			query:    "filter[_id]=5f1a6b9e8f1b2c3d4e5f6a7b,5f1a6b9e8f1b2c3d4e5f6a7c",
+			baseline: bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{id1, id2}}}}},
+		},
+		{
+			name:     "array element",
+			query:    "filter[tags][name]=go",
+			baseline: bson.D{{Key: "tags", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "name", Value: "go"}}}}}},
+		},
+		{
+			name:  "array element or empty array",
+			query: "filter[tags][name]=go,null",
+			baseline: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "tags", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "go"}}}}}}}},
+				bson.D{{Key: "tags", Value: bson.D{{Key: "$size", Value: 0}}}},
+			}}},
+			want: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "tags", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "name", Value: "go"}}}}}},
+				bson.D{{Key: "tags", Value: bson.D{{Key: "$size", Value: 0}}}},
+			}}},
+			change: "equivalent: a single element value is written as a plain value",
+		},
+		{
+			name:  "array element list",
+			query: "filter[tags][name]=go,rust",
+			baseline: bson.D{{Key: "tags", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "name", Value: bson.D{
+				{Key: "$eq", Value: "go"},
+				{Key: "$eq", Value: "rust"},
+			}}}}}}},
+			want:   bson.D{{Key: "tags", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "name", Value: bson.D{{Key: "$in", Value: bson.A{"go", "rust"}}}}}}}}},
+			change: "the repeated $eq keys were not a valid condition",
+		},
+		{
+			name:     "null",
+			query:    "filter[deletedAt]=null",
+			baseline: bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$eq", Value: "null"}}}},
+			want:     bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$eq", Value: nil}}}},
+			change:   "breaking: null is a null value everywhere, not only inside $or",
+		},
+		{
+			name:     "null in list",
+			query:    "filter[deletedAt]=2024,null",
+			baseline: bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$in", Value: bson.A{"2024", "null"}}}}},
+			want:     bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$in", Value: bson.A{"2024", nil}}}}},
+			change:   "breaking: null is a null value everywhere, not only inside $or",
+		},
+		{
+			name:     "comparison prefix",
+			query:    "filter[age]=%3E30",
+			baseline: bson.D{{Key: "age", Value: bson.D{{Key: "$eq", Value: ">30"}}}},
+			want:     bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: "30"}}}},
+			change:   "breaking: value prefixes select the operator",
+		},
+		{
+			name:     "like prefix",
+			query:    "filter[name]=%3C%3D%3Ejo",
+			baseline: bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "<=>jo"}}}},
+			want:     bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "jo"}, {Key: "$options", Value: "mi"}}}},
+			change:   "breaking: value prefixes select the operator",
+		},
+		{
+			name:     "not equal prefix",
+			query:    "filter[name]=!=a",
+			baseline: bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "!=a"}}}},
+			want:     bson.D{{Key: "name", Value: bson.D{{Key: "$ne", Value: "a"}}}},
+			change:   "breaking: value prefixes select the operator",
+		},
+		{
+			name:     "not equal null prefix",
+			query:    "filter[deletedAt]=%3C%3Enull",
+			baseline: bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$eq", Value: "<>null"}}}},
+			want:     bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$ne", Value: nil}}}},
+			change:   "breaking: value prefixes select the operator",
+		},
+		{
+			name:     "less or equal prefix",
+			query:    "filter[age]=%3C%3D5",
+			baseline: bson.D{{Key: "age", Value: bson.D{{Key: "$eq", Value: "<=5"}}}},
+			want:     bson.D{{Key: "age", Value: bson.D{{Key: "$lte", Value: "5"}}}},
+			change:   "breaking: value prefixes select the operator",
+		},
+		{
+			name:     "operator key",
+			query:    "filter[age][$gt]=30",
+			baseline: bson.D{{Key: "age", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "$gt", Value: "30"}}}}}},
+			want:     bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: "30"}}}},
+			change:   "an operator segment applies to the field itself",
+		},
+		{
+			name:     "literal prefix",
+			query:    "filter[name][$eq]=%3Cb%3E",
+			baseline: bson.D{{Key: "name", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "$eq", Value: "<b>"}}}}}},
+			want:     bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "<b>"}}}},
+			change:   "an operator segment applies to the field itself",
+		},
+		{
+			name:  "or",
+			query: "filter[$or][0][name]=a&filter[$or][1][name]=b",
+			baseline: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "a"}}}},
+				bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "b"}}}},
+			}}},
+		},
+		{
+			name:  "or with operator",
+			query: "filter[$or][0][age][$gt]=30&filter[$or][1][name]=b",
+			baseline: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 30}}}},
+				bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "b"}}}},
+			}}},
+		},
+		{
+			name:  "or with array element",
+			query: "filter[$or][0][tags][name]=go&filter[$or][1][name]=b",
+			baseline: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "tags", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "name", Value: "go"}}}}}},
+				bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "b"}}}},
+			}}},
+		},
+		{
+			name:  "or with like",
+			query: "filter[$or][0][name][$like]=jo&filter[$or][1][name]=b",
+			baseline: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "jo"}, {Key: "$options", Value: "mi"}}}},
+				bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "b"}}}},
+			}}},
+		},
+		{
+			name:  "or with number",
+			query: "filter[$or][0][age]=30&filter[$or][1][name]=b",
+			baseline: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{},
+				bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "b"}}}},
+			}}},
+			want: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "age", Value: bson.D{{Key: "$eq", Value: 30}}}},
+				bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "b"}}}},
+			}}},
+			change: "numeric branch values were dropped",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := FromQueryString(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := NewQueryBuilder().Filter(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == nil {
+				want = tt.baseline
+			} else if tt.change == "" {
+				t.Fatal("a changed output needs a reason")
+			}
+			if extJSON(t, got) != extJSON(t, want) {
+				t.Errorf("got %s, want %s", extJSON(t, got), extJSON(t, want))
+			}
+		})
+	}
+}
+
+func TestMongoCompilerElemMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		expr Expr
+		want bson.D
+	}{
+		{
+			name: "element document",
+			expr: ElemMatch{Field: "items", Expr: And{Exprs: []Expr{
+				Compare{Field: "sku", Op: Eq, Value: "a"},
+				Compare{Field: "qty", Op: Gt, Value: 2},
+			}}},
+			want: bson.D{{Key: "items", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+				{Key: "sku", Value: "a"},
+				{Key: "qty", Value: bson.D{{Key: "$gt", Value: 2}}},
+			}}}}},
+		},
+		{
+			name: "scalar element",
+			expr: ElemMatch{Field: "scores", Expr: Compare{Op: Gte, Value: 80}},
+			want: bson.D{{Key: "scores", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "$gte", Value: 80}}}}}},
+		},
+		{
+			name: "negated",
+			expr: Not{Expr: ElemMatch{Field: "tags", Expr: Compare{Field: "name", Op: Eq, Value: "go"}}},
+			want: bson.D{{Key: "tags", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "name", Value: "go"}}}}}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MongoCompiler{}.CompileFilter(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if extJSON(t, got) != extJSON(t, tt.want) {
+				t.Errorf("got %s, want %s", extJSON(t, got), extJSON(t, tt.want))
+			}
+		})
+	}
+}
+
+func extJSON(t *testing.T, doc bson.D) string {
+	t.Helper()
+	data, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMongoCompilerInvalidID(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec IDCodec
+		query string
+		valid bool
+	}{
+		{"object id", ObjectIDCodec{}, "filter[_id]=5f1a6b9e8f1b2c3d4e5f6a7b", true},
+		{"invalid object id", ObjectIDCodec{}, "filter[_id]=nope", false},
+		{"invalid object id in list", ObjectIDCodec{}, "filter[_id]=5f1a6b9e8f1b2c3d4e5f6a7b,nope", false},
+		{"invalid object id with operator", ObjectIDCodec{}, "filter[_id][$ne]=nope", false},
+		{"invalid uuid", UUIDCodec{}, "filter[_id]=nope", false},
+		{"int64", Int64Codec{}, "filter[_id]=42", true},
+		{"invalid int64", Int64Codec{}, "filter[_id]=forty", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := FromQueryString(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qb := NewQueryBuilder()
+			qb.SetIDCodec(tt.codec)
+			_, err = qb.Filter(opt)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidID) || !errors.Is(err, ErrInvalidQuery) {
+				t.Fatalf("got %v, want an ErrInvalidQuery and ErrInvalidID error", err)
+			}
+		})
+	}
+}
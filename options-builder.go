@@ -0,0 +1,280 @@
+package querybuilder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// OptionsBuilder assembles Options in code. Every method produces the same
+// Options as parsing the equivalent query string, so
+//
+//	New().Where("age", Gte, 18).SortDesc("createdAt").Page(2, 20)
+//
+// encodes as "filter[age][$gte]=18&sort=-createdAt&page[page]=2&page[size]=20".
+// Where and Having keep the Go type of numbers, booleans and times, so they
+// are compared as such even on fields no schema declares.
+type OptionsBuilder struct {
+	opt Options
+}
+
+func New() *OptionsBuilder {
+	return &OptionsBuilder{opt: Options{
+		Filter: map[string]interface{}{},
+		Page:   map[string]int{},
+	}}
+}
+
+// Where adds a condition on field. Eq with a slice value matches any of its
+// elements, as do In filters written as filter[field]=a,b.
+func (b *OptionsBuilder) Where(field string, op Operator, value interface{}) *OptionsBuilder {
+	setTerm(b.opt.Filter, field, op, value)
+	return b
+}
+
+func (b *OptionsBuilder) Exists(field string, exists bool) *OptionsBuilder {
+	b.opt.Filter[field+"][$exists"] = []string{fmt.Sprint(exists)}
+	return b
+}
+
+// Or adds one $or branch per builder, each branch holding the filter of that
+// builder. Calling Or again adds branches to the same $or.
+func (b *OptionsBuilder) Or(branches ...*OptionsBuilder) *OptionsBuilder {
+	list, _ := b.opt.Filter["$or"].([]interface{})
+	for _, branch := range branches {
+		m := map[string]interface{}{}
+		keys := sortedKeys(branch.opt.Filter)
+		for _, key := range keys {
+			raw := branch.opt.Filter[key]
+			value := raw
+			if key == "$or" {
+				m[key] = value
+				continue
+			}
+			if values, ok := value.([]string); ok {
+				escaped := make([]string, len(values))
+				for i, v := range values {
+					escaped[i] = escapeCommas(v)
+				}
+				value = strings.Join(escaped, ",")
+			}
+			path := strings.Split(key, "][")
+			if hasKeyPrefix(keys, key+"][") {
+				// field also has operators, nest the equality as one of them
+				path = append(path, "$eq")
+			}
+			if _, err := setValueInMapOrArray(m, path, value); err != nil {
+				m[key] = raw
+			}
+		}
+		list = append(list, m)
+	}
+	b.opt.Filter["$or"] = list
+	return b
+}
+
+func hasKeyPrefix(keys []string, prefix string) bool {
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *OptionsBuilder) Select(fields ...string) *OptionsBuilder {
+	b.opt.Fields = append(b.opt.Fields, fields...)
+	return b
+}
+
+func (b *OptionsBuilder) FieldSet(typ string, fields ...string) *OptionsBuilder {
+	if b.opt.FieldSets == nil {
+		b.opt.FieldSets = map[string][]string{}
+	}
+	b.opt.FieldSets[typ] = append(b.opt.FieldSets[typ], fields...)
+	return b
+}
+
+func (b *OptionsBuilder) Include(paths ...string) *OptionsBuilder {
+	b.opt.Include = append(b.opt.Include, paths...)
+	return b
+}
+
+func (b *OptionsBuilder) SortAsc(fields ...string) *OptionsBuilder {
+	b.opt.Sort = append(b.opt.Sort, fields...)
+	return b
+}
+
+func (b *OptionsBuilder) SortDesc(fields ...string) *OptionsBuilder {
+	for _, field := range fields {
+		b.opt.Sort = append(b.opt.Sort, "-"+field)
+	}
+	return b
+}
+
+// Page selects a zero based page of size documents.
+func (b *OptionsBuilder) Page(page int, size int) *OptionsBuilder {
+	b.opt.Page["page"] = page
+	b.opt.Page["size"] = size
+	b.opt.SetPaginationStrategy(&PageSizeStrategy{})
+	return b
+}
+
+func (b *OptionsBuilder) Limit(limit int, offset int) *OptionsBuilder {
+	b.opt.Page["limit"] = limit
+	b.opt.Page["offset"] = offset
+	return b
+}
+
+func (b *OptionsBuilder) After(values ...string) *OptionsBuilder {
+	b.opt.After = values
+	return b
+}
+
+func (b *OptionsBuilder) GroupBy(fields ...string) *OptionsBuilder {
+	b.opt.Group = append(b.opt.Group, fields...)
+	return b
+}
+
+// Aggregate adds an output computed by spec, "count" or "op:field" as in
+// aggregate[name]=sum:price.
+func (b *OptionsBuilder) Aggregate(name string, spec string) *OptionsBuilder {
+	if b.opt.Aggregate == nil {
+		b.opt.Aggregate = map[string]string{}
+	}
+	b.opt.Aggregate[name] = spec
+	return b
+}
+
+func (b *OptionsBuilder) Having(name string, op Operator, value interface{}) *OptionsBuilder {
+	if b.opt.Having == nil {
+		b.opt.Having = map[string]interface{}{}
+	}
+	setTerm(b.opt.Having, name, op, value)
+	return b
+}
+
+func (b *OptionsBuilder) Facet(facet Facet) *OptionsBuilder {
+	setFacet(&b.opt, facet)
+	return b
+}
+
+// Options returns a copy of the assembled options, so the builder can keep
+// being extended without affecting options already handed out.
+func (b *OptionsBuilder) Options() Options {
+	opt := b.opt
+	opt.Filter = copyTree(b.opt.Filter)
+	opt.Having = copyTree(b.opt.Having)
+	opt.Page = map[string]int{}
+	for key, value := range b.opt.Page {
+		opt.Page[key] = value
+	}
+	opt.After = append([]string(nil), b.opt.After...)
+	opt.Fields = append([]string(nil), b.opt.Fields...)
+	opt.Include = append([]string(nil), b.opt.Include...)
+	opt.Group = append([]string(nil), b.opt.Group...)
+	opt.Sort = append([]string(nil), b.opt.Sort...)
+	opt.Facets = append([]Facet(nil), b.opt.Facets...)
+	if b.opt.FieldSets != nil {
+		opt.FieldSets = map[string][]string{}
+		for typ, fields := range b.opt.FieldSets {
+			opt.FieldSets[typ] = append([]string(nil), fields...)
+		}
+	}
+	if b.opt.Aggregate != nil {
+		opt.Aggregate = map[string]string{}
+		for name, spec := range b.opt.Aggregate {
+			opt.Aggregate[name] = spec
+		}
+	}
+	return opt
+}
+
+func (b *OptionsBuilder) Encode() string {
+	return b.opt.Encode()
+}
+
+// setTerm stores a condition the way the parser stores filter[field]=value
+// for equality and filter[field][$op]=value for every other operator.
+func setTerm(m map[string]interface{}, field string, op Operator, value interface{}) {
+	values := termValue(value)
+	if op == Eq {
+		if v, ok := values.([]string); ok && len(v) == 1 && prefixOperator(v[0]) != "$eq" {
+			m[field+"][$eq"] = values
+			return
+		}
+		m[field] = values
+		return
+	}
+	m[field+"]["+string(op)] = values
+}
+
+// termValue returns value in the form the parser stores it, a []string, unless
+// it holds numbers, booleans or times. Those are kept as they are, a slice of
+// them as a []interface{} list.
+func termValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return append([]string(nil), v...)
+	case nil:
+		return []string{"null"}
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return value
+	}
+	var (
+		values  = make([]interface{}, 0, rv.Len())
+		strs    = make([]string, 0, rv.Len())
+		allStrs = true
+	)
+	for i := 0; i < rv.Len(); i++ {
+		switch v := termValue(rv.Index(i).Interface()).(type) {
+		case []string:
+			for _, s := range v {
+				values = append(values, s)
+			}
+			strs = append(strs, v...)
+		case []interface{}:
+			values = append(values, v...)
+			allStrs = false
+		default:
+			values = append(values, v)
+			allStrs = false
+		}
+	}
+	if allStrs {
+		return strs
+	}
+	return values
+}
+
+func copyTree(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		result[key] = copyValue(value)
+	}
+	return result
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyTree(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = copyValue(item)
+		}
+		return list
+	case []string:
+		return append([]string(nil), v...)
+	default:
+		return v
+	}
+}
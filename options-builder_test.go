@@ -0,0 +1,91 @@
+package querybuilder_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/walkaba/querybuilder"
+	"github.com/walkaba/querybuilder/querybuildertest"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNumericRange(t *testing.T) {
+	collection, err := querybuildertest.NewCollection(
+		bson.M{"name": "a", "age": 12},
+		bson.M{"name": "b", "age": 18},
+		bson.M{"name": "c", "age": 20},
+		bson.M{"name": "d", "age": 41.5},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &querybuilder.Schema{Fields: map[string]querybuilder.Field{
+		"name": {Type: "string", Filterable: true, Selectable: true},
+		"age":  {Type: "int", Filterable: true, Sortable: true, Selectable: true},
+	}}
+	tests := []struct {
+		name   string
+		schema *querybuilder.Schema
+		opt    func() (querybuilder.Options, error)
+		want   int
+	}{
+		{"operator", schema, parse("filter[age][$gte]=18"), 3},
+		{"prefix", schema, parse("filter[age]=>=18"), 3},
+		{"equality", schema, parse("filter[age]=20"), 1},
+		{"list", schema, parse("filter[age]=12,20"), 2},
+		{"range", schema, parse("filter[age][$gt]=12&filter[age][$lte]=20"), 2},
+		{"or", schema, parse("filter[$or][0][age][$lt]=15&filter[$or][1][age][$gt]=40"), 2},
+		{"undeclared string", nil, parse("filter[age][$gte]=18"), 0},
+		{"where", nil, build(querybuilder.New().Where("age", querybuilder.Gte, 18)), 3},
+		{"where float", nil, build(querybuilder.New().Where("age", querybuilder.Gt, 20.5)), 1},
+		{"where list", nil, build(querybuilder.New().Where("age", querybuilder.Eq, []int{12, 18})), 2},
+		{"where between", nil, build(querybuilder.New().Where("age", querybuilder.Gt, 12).Where("age", querybuilder.Lt, 41)), 2},
+		{"where or", nil, build(querybuilder.New().Or(
+			querybuilder.New().Where("age", querybuilder.Lt, 15),
+			querybuilder.New().Where("age", querybuilder.Gte, 41),
+		)), 2},
+		{"where or with operators", nil, build(querybuilder.New().Or(
+			querybuilder.New().Where("age", querybuilder.Eq, []int{12, 20}).Where("age", querybuilder.Gte, 18),
+		)), 1},
+		{"encoded or with operators", schema, parse(querybuilder.New().Or(
+			querybuilder.New().Where("age", querybuilder.Eq, []int{12, 20}).Where("age", querybuilder.Gte, 18),
+		).Encode()), 1},
+		{"encoded where", schema, parse(querybuilder.New().Where("age", querybuilder.Gte, 18).Encode()), 3},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := tt.opt()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rb := querybuilder.NewSearchBuilder(collection)
+			if tt.schema != nil {
+				rb.SetSchema(tt.schema)
+			}
+			cursor, err := rb.FindWithOptions(ctx, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []bson.M
+			if err := cursor.All(ctx, &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d documents %v, want %d", len(got), got, tt.want)
+			}
+		})
+	}
+}
+
+func parse(query string) func() (querybuilder.Options, error) {
+	return func() (querybuilder.Options, error) {
+		return querybuilder.FromQueryString(query)
+	}
+}
+
+func build(b *querybuilder.OptionsBuilder) func() (querybuilder.Options, error) {
+	return func() (querybuilder.Options, error) {
+		return b.Options(), nil
+	}
+}
@@ -1,18 +1,19 @@
 package querybuilder
 
-import (
-	"fmt"
-	"strings"
-)
-
 type Options struct {
 	ps IPaginationStrategy
-	qs string
 
-	Fields []string               `json:"fields,omitempty"`
-	Filter map[string]interface{} `json:"filter,omitempty"`
-	Page   map[string]int         `json:"page"`
-	Sort   []string               `json:"sort,omitempty"`
+	After     []string               `json:"after,omitempty"`
+	Aggregate map[string]string      `json:"aggregate,omitempty"`
+	Facets    []Facet                `json:"facets,omitempty"`
+	Fields    []string               `json:"fields,omitempty"`
+	FieldSets map[string][]string    `json:"fieldSets,omitempty"`
+	Filter    map[string]interface{} `json:"filter,omitempty"`
+	Group     []string               `json:"group,omitempty"`
+	Having    map[string]interface{} `json:"having,omitempty"`
+	Include   []string               `json:"include,omitempty"`
+	Page      map[string]int         `json:"page"`
+	Sort      []string               `json:"sort,omitempty"`
 }
 
 func (o Options) ContainsFilterField(field string) bool {
@@ -30,29 +31,23 @@ func (o Options) ContainsSortField(field string) bool {
 
 func (o Options) First() string {
 	if len(o.Page) == 0 || o.ps == nil {
-		return buildQuerystring(o.Filter, o.Fields, "", o.Sort)
+		return o.encode("")
 	}
-	po := o.ps.First(o.Page)
-	qs := buildQuerystring(o.Filter, o.Fields, po, o.Sort)
-	return qs
+	return o.encode(o.ps.First(o.Page))
 }
 
 func (o Options) Last(total int) string {
 	if len(o.Page) == 0 || o.ps == nil {
-		return buildQuerystring(o.Filter, o.Fields, "", o.Sort)
+		return o.encode("")
 	}
-	po := o.ps.Last(o.Page, total)
-	qs := buildQuerystring(o.Filter, o.Fields, po, o.Sort)
-	return qs
+	return o.encode(o.ps.Last(o.Page, total))
 }
 
 func (o Options) Next() string {
 	if len(o.Page) == 0 || o.ps == nil {
-		return buildQuerystring(o.Filter, o.Fields, "", o.Sort)
+		return o.encode("")
 	}
-	po := o.ps.Next(o.Page)
-	qs := buildQuerystring(o.Filter, o.Fields, po, o.Sort)
-	return qs
+	return o.encode(o.ps.Next(o.Page))
 }
 
 func (o Options) PaginationStrategy() IPaginationStrategy {
@@ -61,93 +56,15 @@ func (o Options) PaginationStrategy() IPaginationStrategy {
 
 func (o Options) Prev() string {
 	if len(o.Page) == 0 || o.ps == nil {
-		return buildQuerystring(o.Filter, o.Fields, "", o.Sort)
+		return o.encode("")
 	}
-	po := o.ps.Prev(o.Page)
-	qs := buildQuerystring(o.Filter, o.Fields, po, o.Sort)
-	return qs
+	return o.encode(o.ps.Prev(o.Page))
 }
 
 func (o *Options) SetPaginationStrategy(ps IPaginationStrategy) {
 	o.ps = ps
 }
 
-func buildFilterQuery(b *strings.Builder, filter map[string]interface{}, prefix string) {
-	ra := false
-
-	for key, value := range filter {
-		if ra {
-			fmt.Fprint(b, "&")
-		}
-		switch v := value.(type) {
-		case map[string]interface{}:
-			newPrefix := key
-			if prefix != "" {
-				newPrefix = prefix + "[" + key + "]"
-			}
-			buildFilterQuery(b, v, newPrefix)
-		case []interface{}:
-			for i, subValue := range v {
-				newPrefix := fmt.Sprintf("%s[%d]", key, i)
-				if prefix != "" {
-					newPrefix = prefix + "[" + newPrefix + "]"
-				}
-				if subMap, ok := subValue.(map[string]interface{}); ok {
-					buildFilterQuery(b, subMap, newPrefix)
-				} else {
-					fmt.Fprintf(b, "filter[%s]=", newPrefix)
-					fmt.Fprint(b, subValue)
-				}
-			}
-		default:
-			fmt.Fprintf(b, "filter[%s]=", prefix+key)
-			fmt.Fprint(b, v)
-		}
-		ra = true
-	}
-}
-
-func buildQuerystring(filter map[string]interface{}, fields []string, page string, sort []string) string {
-	b := strings.Builder{}
-	ra := false
-
-	buildFilterQuery(&b, filter, "")
-
-	if len(fields) > 0 {
-		if ra {
-			fmt.Fprint(&b, "&")
-		}
-		fmt.Fprintf(&b, "fields=")
-		for i, field := range fields {
-			if i > 0 {
-				fmt.Fprint(&b, ",")
-			}
-			fmt.Fprint(&b, field)
-		}
-		ra = true
-	}
-	if page != "" {
-		if ra {
-			fmt.Fprint(&b, "&")
-		}
-		fmt.Fprint(&b, page)
-		ra = true
-	}
-	if len(sort) > 0 {
-		if ra {
-			fmt.Fprint(&b, "&")
-		}
-		fmt.Fprintf(&b, "sort=")
-		for i, field := range sort {
-			if i > 0 {
-				fmt.Fprint(&b, ",")
-			}
-			fmt.Fprint(&b, field)
-		}
-	}
-	return b.String()
-}
-
 func contains(list []string, value string, stripPrefix bool) bool {
 	if len(list) == 0 {
 		return false
@@ -22,7 +22,7 @@ func (ps PageSizeStrategy) First(c map[string]int) string {
 		return ""
 	}
 	p = 0
-	return fmt.Sprintf("page[size]=%d&page[page]=%d", s, p)
+	return fmt.Sprintf("page[page]=%d&page[size]=%d", p, s)
 }
 
 func (os PageSizeStrategy) Last(c map[string]int, total int) string {
@@ -35,8 +35,11 @@ func (os PageSizeStrategy) Last(c map[string]int, total int) string {
 	} else {
 		return ""
 	}
-	p = total / s
-	return fmt.Sprintf("page[size]=%d&page[page]=%d", s, p)
+	p = 0
+	if total > 0 && s > 0 {
+		p = (total - 1) / s
+	}
+	return fmt.Sprintf("page[page]=%d&page[size]=%d", p, s)
 }
 
 func (ps PageSizeStrategy) Next(c map[string]int) string {
@@ -52,9 +55,9 @@ func (ps PageSizeStrategy) Next(c map[string]int) string {
 	if page, ok := c["page"]; ok {
 		p = page + 1
 	} else {
-		p = 0
+		p = 1
 	}
-	return fmt.Sprintf("page[size]=%d&page[page]=%d", s, p)
+	return fmt.Sprintf("page[page]=%d&page[size]=%d", p, s)
 }
 
 func (ps PageSizeStrategy) Prev(c map[string]int) string {
@@ -75,5 +78,5 @@ func (ps PageSizeStrategy) Prev(c map[string]int) string {
 	if p < 0 {
 		p = 0
 	}
-	return fmt.Sprintf("page[size]=%d&page[page]=%d", s, p)
+	return fmt.Sprintf("page[page]=%d&page[size]=%d", p, s)
 }
@@ -3,98 +3,207 @@ package querybuilder
 import (
 	"context"
 	"encoding/json"
-	"errors"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type PaginationBuilder struct {
-	collection *mongo.Collection
+	builderConfig
+	collection Collection
 	route      string
 }
 
-func NewPaginationBuilder(collection *mongo.Collection, route string) *PaginationBuilder {
-	return &PaginationBuilder{collection, route}
+func NewPaginationBuilder(collection Collection, route string) *PaginationBuilder {
+	return &PaginationBuilder{collection: collection, route: route}
 }
 
 func (c *PaginationBuilder) Find(payload string) (*mongo.Cursor, error) {
-	opt, err := FromQueryString(payload)
+	return c.FindContext(context.TODO(), payload)
+}
+
+func (c *PaginationBuilder) FindContext(ctx context.Context, payload string) (*mongo.Cursor, error) {
+	opt, err := c.ParseContext(ctx, payload)
 	if err != nil {
-		return nil, errors.New("invalid query string")
+		return nil, err
 	}
-	queryString := NewQueryBuilder(true)
-	options, err := queryString.FindOptions(opt)
+	return c.FindWithOptions(ctx, opt)
+}
+
+func (c *PaginationBuilder) FindWithOptions(ctx context.Context, opt Options) (*mongo.Cursor, error) {
+	filters, err := c.prepare(ctx, &opt)
 	if err != nil {
 		return nil, err
 	}
-	var filters bson.D
-	if len(opt.Filter) > 0 {
-		filters, err = queryString.Filter(opt)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		filters = bson.D{}
+	options, err := c.findOptions(ctx, opt)
+	if err != nil {
+		return nil, err
 	}
-	cursor, err := c.collection.Find(context.TODO(), filters, options)
+	if err := c.beforeExecute(ctx, &filters, options); err != nil {
+		return nil, err
+	}
+	cursor, err := c.find(ctx, opt, filters, options)
 	if err != nil {
 		if err.Error() != "document is nil" {
 			return nil, err
 		}
 	}
+	if err := c.afterExecute(ctx, cursor); err != nil {
+		return nil, err
+	}
 	return cursor, nil
 }
 
+func (c *PaginationBuilder) find(ctx context.Context, opt Options, filters bson.D, findOptions *options.FindOptions) (*mongo.Cursor, error) {
+	if !c.needsPipeline(ctx, opt) {
+		return c.collection.Find(ctx, filters, findOptions)
+	}
+	pipeline, err := c.pipeline(ctx, opt, filters, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.Aggregate(ctx, pipeline)
+}
+
 func (c *PaginationBuilder) FindOne(payload string) (*mongo.SingleResult, error) {
-	opt, err := FromQueryString(payload)
+	return c.FindOneContext(context.TODO(), payload)
+}
+
+func (c *PaginationBuilder) FindOneContext(ctx context.Context, payload string) (*mongo.SingleResult, error) {
+	opt, err := c.ParseContext(ctx, payload)
 	if err != nil {
-		return nil, errors.New("invalid query string")
+		return nil, err
 	}
-	queryString := NewQueryBuilder(true)
-	var filters bson.D
-	if len(opt.Filter) > 0 {
-		filters, err = queryString.Filter(opt)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		filters = bson.D{}
+	return c.FindOneWithOptions(ctx, opt)
+}
+
+func (c *PaginationBuilder) FindOneWithOptions(ctx context.Context, opt Options) (*mongo.SingleResult, error) {
+	filters, err := c.prepare(ctx, &opt)
+	if err != nil {
+		return nil, err
 	}
-	result := c.collection.FindOne(context.TODO(), filters)
-	return result, nil
+	return c.findOne(ctx, c.collection, opt, filters)
 }
 
 func (c *PaginationBuilder) Pagination(payload string) (*OutPagination, error) {
-	opt, err := FromQueryString(payload)
+	return c.PaginationContext(context.TODO(), payload)
+}
+
+func (c *PaginationBuilder) PaginationContext(ctx context.Context, payload string) (*OutPagination, error) {
+	opt, err := c.ParseContext(ctx, payload)
 	if err != nil {
-		return nil, errors.New("invalid query string")
+		return nil, err
 	}
-	queryString := NewQueryBuilder(true)
-	findOptions, err := queryString.FindOptions(opt)
+	return c.paginate(ctx, opt, payload)
+}
+
+// PaginationWithOptions paginates options returned by Parse or built with New.
+// Meta.Filters holds their canonical query string.
+func (c *PaginationBuilder) PaginationWithOptions(ctx context.Context, opt Options) (*OutPagination, error) {
+	return c.paginate(ctx, opt, opt.Encode())
+}
+
+// paginate keeps payload for Meta.Filters, so string callers see the query
+// they passed in.
+func (c *PaginationBuilder) paginate(ctx context.Context, opt Options, payload string) (*OutPagination, error) {
+	filters, err := c.prepare(ctx, &opt)
 	if err != nil {
 		return nil, err
 	}
-	var filters bson.D
-	if len(opt.Filter) > 0 {
-		filters, err = queryString.Filter(opt)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		filters = bson.D{}
+	findOptions, err := c.findOptions(ctx, opt)
+	if err != nil {
+		return nil, err
 	}
-	count, err := c.collection.CountDocuments(context.TODO(), filters)
+	if err := c.beforeExecute(ctx, &filters, findOptions); err != nil {
+		return nil, err
+	}
+	count, err := c.count(ctx, c.collection, opt, filters)
 	if err != nil {
 		if err.Error() != "document is nil" {
 			return nil, err
 		}
 	}
-	cursor, err := c.collection.Find(context.TODO(), filters, findOptions)
+	cursor, err := c.find(ctx, opt, filters, findOptions)
 	if err != nil {
 		if err.Error() != "document is nil" {
 			return nil, err
 		}
 	}
+	var facets map[string][]FacetCount
+	if len(opt.Facets) > 0 {
+		facets, err = c.facets(ctx, opt, filters)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.result(ctx, opt, payload, cursor, count, facets)
+}
+
+func (c *PaginationBuilder) Aggregate(payload string) (*OutPagination, error) {
+	return c.AggregateContext(context.TODO(), payload)
+}
+
+func (c *PaginationBuilder) AggregateContext(ctx context.Context, payload string) (*OutPagination, error) {
+	opt, err := c.ParseContext(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	return c.aggregate(ctx, opt, payload)
+}
+
+func (c *PaginationBuilder) AggregateWithOptions(ctx context.Context, opt Options) (*OutPagination, error) {
+	return c.aggregate(ctx, opt, opt.Encode())
+}
+
+func (c *PaginationBuilder) aggregate(ctx context.Context, opt Options, payload string) (*OutPagination, error) {
+	filters, err := c.prepare(ctx, &opt)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.beforeExecute(ctx, &filters, nil); err != nil {
+		return nil, err
+	}
+	pipeline, err := c.queryBuilder().aggregationPipeline(opt, filters, c.storageName)
+	if err != nil {
+		return nil, err
+	}
+	pipeline = append(c.virtualStages(ctx, opt), pipeline...)
+	countPipeline := append(mongo.Pipeline{}, pipeline...)
+	countPipeline = append(countPipeline, bson.D{{Key: "$count", Value: "total"}})
+	countCursor, err := c.collection.Aggregate(ctx, countPipeline)
+	if err != nil {
+		return nil, err
+	}
+	var counts []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := countCursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	var count int64
+	if len(counts) > 0 {
+		count = counts[0].Total
+	}
+	cursor, err := c.collection.Aggregate(ctx, append(pipeline, pageStages(opt.Page)...))
+	if err != nil {
+		return nil, err
+	}
+	return c.result(ctx, opt, payload, cursor, count, nil)
+}
+
+func (c *PaginationBuilder) result(ctx context.Context, opt Options, payload string, cursor *mongo.Cursor, count int64, facets map[string][]FacetCount) (*OutPagination, error) {
+	result, err := c.output(opt, payload, cursor, count, facets)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.afterExecute(ctx, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *PaginationBuilder) output(opt Options, payload string, cursor *mongo.Cursor, count int64, facets map[string][]FacetCount) (*OutPagination, error) {
 	var result OutPagination
 	page := int64(opt.Page["page"])
 	size := int64(opt.Page["size"])
@@ -104,10 +213,14 @@ func (c *PaginationBuilder) Pagination(payload string) (*OutPagination, error) {
 	meta.Page.PerPage = size
 	meta.Page.Total = count
 	meta.Filters = payload
+	meta.Facets = facets
 	bytes, err := json.Marshal(meta)
 	if err != nil {
 		return nil, err
 	}
 	result.Meta = bytes
+	result.Links = c.LinkHeader(opt, count)
+	result.Total = count
+	result.Options = opt
 	return &result, nil
 }
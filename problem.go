@@ -0,0 +1,40 @@
+package querybuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func NewProblem(status int, err error) Problem {
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+	if err != nil {
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+func WriteProblem(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(NewProblem(status, err))
+}
+
+func problemStatus(err error) int {
+	if errors.Is(err, ErrMissingScope) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
@@ -1,7 +1,6 @@
 package querybuilder
 
 import (
-	"errors"
 	"fmt"
 	"net/url"
 	"regexp"
@@ -9,33 +8,30 @@ import (
 	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	bracketRE      = regexp.MustCompile(`(?P<typ>filter|sort|page)\[([^&]+?)\](\={1})`)
-	bracketValueRE = regexp.MustCompile(`\]\=(.*?)(\&|\z)`)
-	commaRE        = regexp.MustCompile(`\s?\,\s?`)
-	fieldsRE       = regexp.MustCompile(`fields=(?P<field>.+?)(\&|\z)`)
-	sortRE         = regexp.MustCompile(`sort=(?P<field>.+?)(\&|\z)`)
+	bracketRE = regexp.MustCompile(`^(filter|sort|page|fields|aggregate|having|facet)\[(.+)\]$`)
+	commaRE   = regexp.MustCompile(`\s?\,\s?`)
 )
 
 func FromQueryString(qs string) (Options, error) {
 	if qs == "" {
 		return Options{}, nil
 	}
-	uqs, err := url.QueryUnescape(qs)
+	params, err := queryParams(qs)
 	if err != nil {
 		return Options{}, err
 	}
 	options := Options{
-		qs: uqs,
+		Filter: map[string]interface{}{},
+		Page:   map[string]int{},
 	}
-	options.Fields = parseFields(&uqs)
-	options.Sort = parseSort(&uqs)
-	if err := parseBracketParams(uqs, &options); err != nil {
-		return options, err
+	for _, param := range params {
+		if err := options.setParam(param[0], param[1]); err != nil {
+			return options, err
+		}
 	}
 	if _, ok := options.Page["size"]; ok {
 		options.SetPaginationStrategy(&PageSizeStrategy{})
@@ -43,105 +39,115 @@ func FromQueryString(qs string) (Options, error) {
 	return options, nil
 }
 
-func parseFields(qs *string) []string {
-	var fields []string
-	fieldNames := fieldsRE.FindAllStringSubmatch(extract(qs, *fieldsRE), -1)
-	for fieldNames != nil {
-		for _, field := range fieldNames {
-			if commaRE.MatchString(field[1]) {
-				fa := commaRE.Split(field[1], -1)
-				fields = append(fields, fa...)
-				continue
-			}
-
-			fields = append(fields, field[1])
+// queryParams splits qs on '&' before unescaping each key and value, so that
+// escaped delimiters inside a value are kept as part of it.
+func queryParams(qs string) ([][2]string, error) {
+	var params [][2]string
+	for _, param := range strings.Split(qs, "&") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
 		}
-		fieldNames = fieldsRE.FindAllStringSubmatch(extract(qs, *fieldsRE), -1)
-	}
-
-	return fields
-}
-
-func extract(qs *string, re regexp.Regexp) string {
-	cords := re.FindStringIndex(*qs)
-	var r string
-	if cords != nil {
-		if cords[0] == 0 {
-			r = (*qs)[cords[0]:cords[1]]
-			*qs = (*qs)[cords[1]:]
-			return r
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			return nil, err
 		}
-		if cords[1] == len(*qs) {
-			r = (*qs)[cords[0]:cords[1]]
-			*qs = (*qs)[0:cords[0]]
-			return r
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return nil, err
 		}
-		r = (*qs)[cords[0]:cords[1]]
-		*qs = fmt.Sprintf("%s%s", (*qs)[0:cords[0]], (*qs)[cords[1]:])
-		return r
+		params = append(params, [2]string{key, value})
 	}
-	return r
+	return params, nil
 }
 
-func parseSort(qs *string) []string {
-	var sort []string
-	fieldNames := sortRE.FindAllStringSubmatch(extract(qs, *sortRE), -1)
-	for fieldNames != nil {
-		for _, field := range fieldNames {
-			if commaRE.MatchString(field[1]) {
-				fa := commaRE.Split(field[1], -1)
-				sort = append(sort, fa...)
-				continue
-			}
-			sort = append(sort, field[1])
+func (o *Options) setParam(key string, value string) error {
+	switch key {
+	case "fields":
+		o.Fields = append(o.Fields, parseList(value)...)
+		return nil
+	case "sort":
+		o.Sort = append(o.Sort, parseList(value)...)
+		return nil
+	case "include":
+		o.Include = append(o.Include, parseList(value)...)
+		return nil
+	case "group":
+		o.Group = append(o.Group, parseList(value)...)
+		return nil
+	case "facets":
+		for _, field := range parseList(value) {
+			setFacet(o, Facet{Field: field})
 		}
-		fieldNames = sortRE.FindAllStringSubmatch(extract(qs, *sortRE), -1)
+		return nil
 	}
-	return sort
-}
-
-func isUnableToParse(terms [][]string, values [][]string) error {
-	if len(terms) > 0 && len(terms) > len(values) {
-		return errors.New("unable to parse: an object hierarchy has been provided")
+	term := bracketRE.FindStringSubmatch(key)
+	if term == nil {
+		return nil
+	}
+	switch term[1] {
+	case "filter":
+		return SetJSONValue(term[2], value, o.Filter)
+	case "fields":
+		if o.FieldSets == nil {
+			o.FieldSets = map[string][]string{}
+		}
+		o.FieldSets[term[2]] = append(o.FieldSets[term[2]], splitList(value)...)
+	case "aggregate":
+		if o.Aggregate == nil {
+			o.Aggregate = map[string]string{}
+		}
+		o.Aggregate[term[2]] = value
+	case "facet":
+		facet, err := parseFacet(term[2], value)
+		if err != nil {
+			return err
+		}
+		setFacet(o, facet)
+	case "having":
+		if o.Having == nil {
+			o.Having = map[string]interface{}{}
+		}
+		return SetJSONValue(term[2], value, o.Having)
+	case "page":
+		if term[2] == "after" {
+			o.After = splitList(value)
+			return nil
+		}
+		v, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return err
+		}
+		o.Page[term[2]] = int(v)
 	}
 	return nil
 }
 
-func parseBracketParams(qs string, o *Options) error {
-	o.Filter = map[string]interface{}{}
-	o.Page = map[string]int{}
-	terms := bracketRE.FindAllStringSubmatch(qs, -1)
-	values := bracketValueRE.FindAllStringSubmatch(qs, -1)
-	err := isUnableToParse(terms, values)
-	if err != nil {
-		return err
-	}
-	if o.Filter == nil {
-		o.Filter = map[string]interface{}{}
+func parseList(value string) []string {
+	if value == "" {
+		return nil
 	}
-	if o.Page == nil {
-		o.Page = map[string]int{}
+	return splitList(value)
+}
+
+// splitList splits a comma separated value. A comma written as \, belongs to
+// the value, which is how Encode writes values containing commas.
+func splitList(value string) []string {
+	if !strings.Contains(value, `\,`) {
+		return commaRE.Split(value, -1)
 	}
-	for i, term := range terms {
-		switch strings.ToLower(term[1]) {
-		case "filter":
-			err := SetJSONValue(term[2], values[i][1], o.Filter)
-			if err != nil {
-				return err
-			}
-		case "page":
-			v, err := strconv.ParseInt(values[i][1], 0, 64)
-			if err != nil {
-				return err
-			}
-			o.Page[term[2]] = int(v)
-		}
+	parts := commaRE.Split(strings.ReplaceAll(value, `\,`, "\x00"), -1)
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(part, "\x00", ",")
 	}
-	return nil
+	return parts
 }
 
 func validateValue(value interface{}) interface{} {
-	str := value.(string)
+	str, ok := value.(string)
+	if !ok {
+		return value
+	}
 	if valueInterger, err := strconv.Atoi(str); err == nil {
 		return valueInterger
 	} else if valueFloat, err := strconv.ParseFloat(str, 64); err == nil {
@@ -151,13 +157,28 @@ func validateValue(value interface{}) interface{} {
 	}
 }
 
-func setValueInMapOrArray(current interface{}, keys []string, value interface{}) interface{} {
+// maxArrayIndex bounds the array indexes of a filter path when no Limits
+// set a lower one, so a single parameter cannot allocate a huge array.
+const maxArrayIndex = 100
+
+// setValueInMapOrArray stores value at keys below current. A path that is
+// already used as a value cannot become a map or array, nor the other way
+// around.
+func setValueInMapOrArray(current interface{}, keys []string, value interface{}) (interface{}, error) {
 	if len(keys) == 0 {
+		switch current.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%w: filter path is used both as a value and as a map or array", ErrInvalidQuery)
+		}
 		strVal, ok := value.(string)
 		if ok && strings.Contains(strVal, ",") {
-			return strings.Split(strVal, ",")
+			values := splitList(strVal)
+			if len(values) > 1 {
+				return values, nil
+			}
+			return values[0], nil
 		}
-		return validateValue(value)
+		return validateValue(value), nil
 	}
 
 	key := keys[0]
@@ -165,9 +186,15 @@ func setValueInMapOrArray(current interface{}, keys []string, value interface{})
 
 	index, err := strconv.Atoi(key)
 	if err == nil {
-		var array []interface{}
-		if current != nil {
-			array = current.([]interface{})
+		if index < 0 {
+			return nil, fmt.Errorf("%w: negative array index %d", ErrInvalidQuery, index)
+		}
+		if index >= maxArrayIndex {
+			return nil, &LimitError{Limit: "array index", Max: maxArrayIndex}
+		}
+		array, ok := current.([]interface{})
+		if !ok && current != nil {
+			return nil, fmt.Errorf("%w: filter path %s is used both as a value and as an array", ErrInvalidQuery, key)
 		}
 
 		if len(array) <= index {
@@ -176,32 +203,41 @@ func setValueInMapOrArray(current interface{}, keys []string, value interface{})
 			array = newArray
 		}
 
-		array[index] = setValueInMapOrArray(array[index], remainingKeys, value)
-		return array
+		item, err := setValueInMapOrArray(array[index], remainingKeys, value)
+		if err != nil {
+			return nil, err
+		}
+		array[index] = item
+		return array, nil
 	} else {
-		var m map[string]interface{}
-		if current != nil {
-			m = current.(map[string]interface{})
-		} else {
+		m, ok := current.(map[string]interface{})
+		if !ok && current != nil {
+			return nil, fmt.Errorf("%w: filter path %s is used both as a value and as a map", ErrInvalidQuery, key)
+		}
+		if m == nil {
 			m = make(map[string]interface{})
 		}
 
-		m[key] = setValueInMapOrArray(m[key], remainingKeys, value)
-		return m
+		item, err := setValueInMapOrArray(m[key], remainingKeys, value)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = item
+		return m, nil
 	}
 }
 
 func SetJSONValue(path string, value interface{}, filter map[string]interface{}) error {
+	if path == "$or" {
+		return fmt.Errorf("%w: $or takes indexed branches", ErrInvalidQuery)
+	}
 	if strings.HasPrefix(path, "$or][") {
 		keys := strings.Split(path, "][")
-		result := setValueInMapOrArray(filter, keys, value)
-		for k, v := range result.(map[string]interface{}) {
-			filter[k] = v
-		}
-		return nil
+		_, err := setValueInMapOrArray(filter, keys, value)
+		return err
 	} else {
 		if commaRE.MatchString(value.(string)) {
-			filter[path] = commaRE.Split(value.(string), -1)
+			filter[path] = splitList(value.(string))
 			return nil
 		}
 		filter[path] = []string{value.(string)}
@@ -211,6 +247,7 @@ func SetJSONValue(path string, value interface{}, filter map[string]interface{})
 
 type QueryBuilder struct {
 	fieldTypes       map[string]string
+	idCodec          IDCodec
 	strictValidation bool
 }
 
@@ -225,6 +262,17 @@ func NewQueryBuilder(strictValidation ...bool) *QueryBuilder {
 	return &qb
 }
 
+func (qb *QueryBuilder) SetIDCodec(codec IDCodec) {
+	qb.idCodec = codec
+}
+
+func (qb QueryBuilder) codec() IDCodec {
+	if qb.idCodec == nil {
+		return ObjectIDCodec{}
+	}
+	return qb.idCodec
+}
+
 func (qb QueryBuilder) setPaginationOptions(pagination map[string]int, opts *options.FindOptions) {
 	if limit, ok := pagination["limit"]; ok {
 		opts.SetLimit(int64(limit))
@@ -274,7 +322,7 @@ func (qb QueryBuilder) setSortOptions(fields []string, opts *options.FindOptions
 	if len(fields) == 0 {
 		return nil
 	}
-	sort := map[string]int{}
+	sort := bson.D{}
 	for _, field := range fields {
 		val := 1
 		if field[0:1] == "-" {
@@ -284,12 +332,12 @@ func (qb QueryBuilder) setSortOptions(fields []string, opts *options.FindOptions
 		if field[0:1] == "+" {
 			field = field[1:]
 		}
-		if qb.strictValidation {
+		if qb.strictValidation && field != "_id" {
 			if _, ok := qb.fieldTypes[field]; !ok {
 				return fmt.Errorf("field %s does not exist in collection", field)
 			}
 		}
-		sort[field] = val
+		sort = append(sort, bson.E{Key: field, Value: val})
 	}
 	opts.SetSort(sort)
 	return nil
@@ -308,51 +356,11 @@ func (qb QueryBuilder) FindOptions(qo Options) (*options.FindOptions, error) {
 }
 
 func (qb QueryBuilder) Filter(opt Options) (bson.D, error) {
-	filters := parseFilters(opt.Filter)
-	return filters, nil
-
-}
-
-func parseFilters(filter map[string]interface{}) bson.D {
-	var filters bson.D
-	for k, v := range filter {
-		hasReservedKey := isReservedKey(k)
-		switch v := v.(type) {
-		case []interface{}:
-			subParts, _ := processArray(k, v)
-			filters = append(filters, bson.E{Key: k, Value: subParts})
-			continue
-		case []string:
-			if hasReservedKey {
-				subParts, _ := processMap(k, v)
-				for key, value := range subParts {
-					filters = append(filters, bson.E{Key: key, Value: value})
-				}
-				continue
-			}
-			subParts := checkFilter(k, v)
-			filters = append(filters, subParts...)
-			continue
-		case string:
-			if hasReservedKey {
-				subParts, _ := processMap(k, v)
-				for key, value := range subParts {
-					filters = append(filters, bson.E{Key: key, Value: value})
-				}
-				continue
-			}
-			subParts := checkFilter(k, []string{v})
-			filters = append(filters, subParts...)
-			continue
-		default:
-			subParts, _ := processMap(k, v)
-			for key, value := range subParts {
-				filters = append(filters, bson.E{Key: key, Value: value})
-			}
-			continue
-		}
+	expr, err := ParseFilter(opt.Filter)
+	if err != nil {
+		return nil, err
 	}
-	return filters
+	return MongoCompiler{IDCodec: qb.codec(), FieldTypes: qb.fieldTypes}.CompileFilter(expr)
 }
 
 func compareOperator(value string) string {
@@ -375,236 +383,3 @@ func compareOperator(value string) string {
 		return "$eq"
 	}
 }
-
-func checkConstraints(values string) string {
-	constraints := []string{"<>", "<=", ">=", "!=", "<", ">", "<=>"}
-	for _, constr := range constraints {
-		result := strings.Split(values, constr)
-		if len(result) > 1 {
-			return compareOperator(values)
-		}
-	}
-	return "$eq"
-}
-
-func checkFilter(field string, values []string) bson.D {
-	key := strings.Split(field, "][")
-	if len(key) == 1 {
-		if len(values) > 1 {
-			check := checkConstraints(values[0])
-			if check == "$lt" || check == "$gt" || check == "$gte" || check == "$lte" {
-				var acc bson.D
-				for _, value := range values {
-					acc = append(acc,
-						bson.E{
-							Key:   check,
-							Value: value,
-						},
-					)
-				}
-				return bson.D{{
-					Key:   field,
-					Value: acc,
-				}}
-			}
-
-			var includes []string
-			for _, value := range values {
-				includes = append(includes, value)
-			}
-			return bson.D{{
-				Key: field,
-				Value: bson.D{{
-					Key: "$in", Value: includes,
-				}},
-			}}
-		}
-		if field == "_id" {
-			res, _ := primitive.ObjectIDFromHex(values[0])
-			return bson.D{{
-				Key: field,
-				Value: bson.D{{
-					Key: "$eq", Value: res,
-				}},
-			}}
-		}
-		check := checkConstraints(values[0])
-		if check == "like" {
-			return bson.D{{
-				Key: field,
-				Value: bson.D{{
-					Key: "$regex", Value: values[0],
-				}},
-			}}
-		}
-		return bson.D{{
-			Key: field,
-			Value: bson.D{{
-				Key:   check,
-				Value: values[0],
-			}},
-		}}
-	} else {
-		if len(values) > 1 {
-			check := checkConstraints(values[0])
-			var acc bson.D
-			isNull := false
-			for _, value := range values {
-				if value != "null" {
-					acc = append(acc,
-						bson.E{
-							Key:   check,
-							Value: value,
-						},
-					)
-				} else {
-					isNull = true
-				}
-			}
-
-			if isNull {
-				return bson.D{
-					{
-						Key: "$or",
-						Value: bson.A{
-							bson.D{{
-								Key: key[0],
-								Value: bson.M{
-									"$elemMatch": bson.M{
-										key[1]: acc}}},
-							},
-							bson.D{
-								{Key: key[0], Value: bson.M{"$size": 0}},
-							},
-						},
-					},
-				}
-			}
-			return bson.D{{
-				Key: key[0], Value: bson.D{{
-					Key: "$elemMatch", Value: bson.D{{
-						Key: key[1], Value: acc,
-					}},
-				}},
-			}}
-		}
-
-		return bson.D{{
-			Key: key[0], Value: bson.D{{
-				Key: "$elemMatch", Value: bson.D{{
-					Key: key[1], Value: values[0],
-				}},
-			}},
-		}}
-	}
-}
-
-func processArray(operator string, value interface{}) ([]interface{}, error) {
-	values, ok := value.([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid format for %s", operator)
-	}
-	var parts []interface{}
-	for _, v := range values {
-		if result, ok := v.(map[string]interface{}); ok {
-			subPart := parseFilters(result)
-			parts = append(parts, subPart)
-			continue
-		} else {
-			parts = append(parts, v)
-			continue
-		}
-	}
-	return parts, nil
-}
-
-func processMap(key string, value interface{}) (map[string]interface{}, error) {
-	subMap, ok := value.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid format for %s", key)
-	}
-	result := make(map[string]interface{})
-	hasReservedKey := false
-	for subKey, subValue := range subMap {
-		if isReservedKey(subKey) {
-			hasReservedKey = true
-		}
-		switch subKey {
-		case "$in":
-			result[subKey] = formatArray(subValue)
-			continue
-		case "$size", "$not", "$lte", "$gte", "$ne", "$lt", "$gt", "$eq":
-			result[subKey] = subValue
-			continue
-		case "$like":
-			result["$regex"] = subValue
-			result["$options"] = "mi"
-			continue
-		default:
-			result[subKey] = formatValue(subValue)
-			continue
-		}
-	}
-	if len(result) > 0 && !hasReservedKey {
-		return map[string]interface{}{key: map[string]interface{}{"$elemMatch": result}}, nil
-	}
-	return map[string]interface{}{key: result}, nil
-}
-
-func isReservedKey(key string) bool {
-	reservedKeys := map[string]bool{
-		"$in":        true,
-		"$size":      true,
-		"$or":        true,
-		"$not":       true,
-		"$lte":       true,
-		"$gte":       true,
-		"$ne":        true,
-		"$lt":        true,
-		"$gt":        true,
-		"$like":      true,
-		"$eq":        true,
-		"$elemMatch": true,
-	}
-	return reservedKeys[key]
-}
-
-func formatArray(value interface{}) []interface{} {
-	valuesAsStringList, ok := value.([]string)
-	if ok {
-		interfaceSlice := make([]interface{}, len(valuesAsStringList))
-		for i, v := range valuesAsStringList {
-			interfaceSlice[i] = v
-		}
-		return interfaceSlice
-	}
-	values, ok := value.([]interface{})
-	if !ok {
-		return []interface{}{value.(string)}
-	}
-	var parts []interface{}
-	fmt.Println(value)
-	for _, v := range values {
-		parts = append(parts, formatValue(v))
-	}
-	return parts
-}
-
-func formatValue(value interface{}) interface{} {
-	switch v := value.(type) {
-	case string:
-		if v == "null" {
-			return nil
-		}
-		return v
-	case float64, int, bool:
-		return v
-	case []interface{}, interface{}:
-		if result, ok := v.(map[string]interface{}); ok {
-			return parseFilters(result)
-		}
-		return v
-	default:
-		return v
-	}
-}
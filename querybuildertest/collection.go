@@ -0,0 +1,718 @@
+package querybuildertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/walkaba/querybuilder"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection is an in-memory querybuilder.Collection. Filters are evaluated
+// with querybuilder.MatchDocument, and aggregations support the $match,
+// $sort, $skip, $limit, $project, $addFields, $set, $unset, $count, $group,
+// $bucket, $sortByCount, $unwind and $facet stages. A collection knows no
+// other collections, so $lookup returns an error, as does any other stage.
+type Collection struct {
+	mu   sync.Mutex
+	docs []bson.M
+}
+
+var _ querybuilder.Collection = (*Collection)(nil)
+
+func NewCollection(docs ...interface{}) (*Collection, error) {
+	c := &Collection{}
+	for _, doc := range docs {
+		if _, err := c.InsertOne(context.Background(), doc); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Collection) Documents() []bson.M {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]bson.M{}, c.docs...)
+}
+
+func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	stages, err := toStages(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := applyStages(c.Documents(), stages)
+	if err != nil {
+		return nil, err
+	}
+	return cursor(docs)
+}
+
+func applyStages(docs []bson.M, stages []bson.D) ([]bson.M, error) {
+	for _, stage := range stages {
+		if len(stage) != 1 {
+			return nil, errors.New("a pipeline stage must have exactly one field")
+		}
+		var err error
+		docs, err = applyStage(docs, stage[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	docs, err := c.match(filter)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(docs)), nil
+}
+
+func (c *Collection) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	docs, err := c.match(filter)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, doc := range docs {
+		value, ok := doc[fieldName]
+		if !ok {
+			continue
+		}
+		items := []interface{}{value}
+		if list, ok := value.(bson.A); ok {
+			items = list
+		}
+		for _, item := range items {
+			if !containsValue(values, item) {
+				values = append(values, item)
+			}
+		}
+	}
+	return values, nil
+}
+
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	docs, err := c.match(filter)
+	if err != nil {
+		return nil, err
+	}
+	opt := options.MergeFindOptions(opts...)
+	if opt.Sort != nil {
+		sortFields, err := sortKeys(opt.Sort)
+		if err != nil {
+			return nil, err
+		}
+		querybuilder.SortDocuments(docs, sortFields)
+	}
+	if opt.Skip != nil {
+		if *opt.Skip < 0 {
+			return nil, errors.New("skip must not be negative")
+		}
+		docs = docs[min(int(*opt.Skip), len(docs)):]
+	}
+	if opt.Limit != nil && *opt.Limit != 0 {
+		limit := *opt.Limit
+		if limit < 0 {
+			limit = -limit
+		}
+		docs = docs[:min(int(limit), len(docs))]
+	}
+	if opt.Projection != nil {
+		if docs, err = project(docs, opt.Projection); err != nil {
+			return nil, err
+		}
+	}
+	return cursor(docs)
+}
+
+func (c *Collection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	docs, err := c.match(filter)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	if len(docs) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	opt := options.MergeFindOneOptions(opts...)
+	if opt.Projection != nil {
+		if docs, err = project(docs[:1], opt.Projection); err != nil {
+			return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+		}
+	}
+	return mongo.NewSingleResultFromDocument(docs[0], nil, nil)
+}
+
+func (c *Collection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.docs {
+		if querybuilder.MatchDocument(querybuilder.Compare{Field: "_id", Op: querybuilder.Eq, Value: doc["_id"]}, existing) {
+			return nil, fmt.Errorf("duplicate key %v", doc["_id"])
+		}
+	}
+	c.docs = append(c.docs, doc)
+	return &mongo.InsertOneResult{InsertedID: doc["_id"]}, nil
+}
+
+func (c *Collection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	expr, err := filterExpr(filter)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, doc := range c.docs {
+		if !querybuilder.MatchDocument(expr, doc) {
+			continue
+		}
+		updated, err := applyUpdate(doc, ops)
+		if err != nil {
+			return nil, err
+		}
+		c.docs[i] = updated
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+	}
+	return &mongo.UpdateResult{}, nil
+}
+
+func (c *Collection) match(filter interface{}) ([]bson.M, error) {
+	expr, err := filterExpr(filter)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	for _, doc := range c.Documents() {
+		if querybuilder.MatchDocument(expr, doc) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func applyUpdate(doc bson.M, ops bson.M) (bson.M, error) {
+	updated := bson.M{}
+	for key, value := range doc {
+		updated[key] = value
+	}
+	for op, value := range ops {
+		fields, err := toDocument(value)
+		if err != nil {
+			return nil, err
+		}
+		for field, v := range fields {
+			switch op {
+			case "$set":
+				updated[field] = v
+			case "$unset":
+				delete(updated, field)
+			case "$inc":
+				sum, err := increment(updated[field], v)
+				if err != nil {
+					return nil, err
+				}
+				updated[field] = sum
+			default:
+				return nil, fmt.Errorf("update operator %s is not supported", op)
+			}
+		}
+	}
+	return updated, nil
+}
+
+func increment(current interface{}, by interface{}) (interface{}, error) {
+	switch b := by.(type) {
+	case int:
+		by = int64(b)
+	case int32:
+		by = int64(b)
+	}
+	switch v := current.(type) {
+	case nil:
+		return by, nil
+	case int32:
+		current = int64(v)
+	case int:
+		current = int64(v)
+	}
+	x, ok1 := current.(int64)
+	y, ok2 := by.(int64)
+	if ok1 && ok2 {
+		return x + y, nil
+	}
+	fx, ok1 := toFloat(current)
+	fy, ok2 := toFloat(by)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("cannot increment %v by %v", current, by)
+	}
+	return fx + fy, nil
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func cursor(docs []bson.M) (*mongo.Cursor, error) {
+	items := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		items[i] = doc
+	}
+	return mongo.NewCursorFromDocuments(items, nil, nil)
+}
+
+func toDocument(value interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if querybuilder.MatchDocument(querybuilder.Compare{Field: "v", Op: querybuilder.Eq, Value: value}, bson.M{"v": v}) {
+			return true
+		}
+	}
+	return false
+}
+
+// project follows the projection semantics of querybuilder.Evaluate, where
+// dotted paths reach into embedded documents.
+func project(docs []bson.M, projection interface{}) ([]bson.M, error) {
+	prj, err := toDocument(projection)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for key, value := range prj {
+		if truthy(value) {
+			fields = append(fields, key)
+			continue
+		}
+		fields = append(fields, "-"+key)
+	}
+	return querybuilder.Evaluate(querybuilder.Options{Fields: fields}, docs)
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case int32:
+		return v != 0
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	default:
+		return true
+	}
+}
+
+func sortKeys(sort interface{}) ([]string, error) {
+	d, err := toD(sort)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for _, e := range d {
+		if n, ok := toInt(e.Value); ok && n < 0 {
+			fields = append(fields, "-"+e.Key)
+			continue
+		}
+		fields = append(fields, e.Key)
+	}
+	return fields, nil
+}
+
+func toStages(pipeline interface{}) ([]bson.D, error) {
+	switch p := pipeline.(type) {
+	case mongo.Pipeline:
+		return p, nil
+	case []bson.D:
+		return p, nil
+	case bson.A:
+		var stages []bson.D
+		for _, item := range p {
+			stage, ok := item.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("invalid pipeline stage %v", item)
+			}
+			stages = append(stages, stage)
+		}
+		return stages, nil
+	default:
+		return nil, fmt.Errorf("unsupported pipeline type %T", pipeline)
+	}
+}
+
+func applyStage(docs []bson.M, stage bson.E) ([]bson.M, error) {
+	switch stage.Key {
+	case "$match":
+		expr, err := filterExpr(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+		var matched []bson.M
+		for _, doc := range docs {
+			if querybuilder.MatchDocument(expr, doc) {
+				matched = append(matched, doc)
+			}
+		}
+		return matched, nil
+	case "$sort":
+		fields, err := sortKeys(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+		querybuilder.SortDocuments(docs, fields)
+		return docs, nil
+	case "$skip":
+		n, ok := toInt(stage.Value)
+		if !ok || n < 0 {
+			return nil, errors.New("invalid $skip")
+		}
+		return docs[min(n, len(docs)):], nil
+	case "$limit":
+		n, ok := toInt(stage.Value)
+		if !ok || n <= 0 {
+			return nil, errors.New("invalid $limit")
+		}
+		return docs[:min(n, len(docs))], nil
+	case "$project":
+		return project(docs, stage.Value)
+	case "$addFields", "$set":
+		fields, err := toD(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]bson.M, len(docs))
+		for i, doc := range docs {
+			out := bson.M{}
+			for key, value := range doc {
+				out[key] = value
+			}
+			for _, field := range fields {
+				value, err := evalExpr(doc, field.Value)
+				if err != nil {
+					return nil, err
+				}
+				out[field.Key] = value
+			}
+			result[i] = out
+		}
+		return result, nil
+	case "$unset":
+		names, ok := stage.Value.(bson.A)
+		if !ok {
+			names = bson.A{stage.Value}
+		}
+		prj := bson.M{}
+		for _, name := range names {
+			prj[fmt.Sprint(name)] = 0
+		}
+		return project(docs, prj)
+	case "$count":
+		return []bson.M{{fmt.Sprint(stage.Value): int64(len(docs))}}, nil
+	case "$group":
+		return group(docs, stage.Value)
+	case "$bucket":
+		return bucket(docs, stage.Value)
+	case "$sortByCount":
+		grouped, err := group(docs, bson.D{
+			{Key: "_id", Value: stage.Value},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		querybuilder.SortDocuments(grouped, []string{"-count"})
+		return grouped, nil
+	case "$unwind":
+		return unwind(docs, stage.Value)
+	case "$facet":
+		facets, err := toD(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+		out := bson.M{}
+		for _, facet := range facets {
+			stages, err := toStages(facet.Value)
+			if err != nil {
+				return nil, err
+			}
+			result, err := applyStages(append([]bson.M{}, docs...), stages)
+			if err != nil {
+				return nil, err
+			}
+			list := bson.A{}
+			for _, doc := range result {
+				list = append(list, doc)
+			}
+			out[facet.Key] = list
+		}
+		return []bson.M{out}, nil
+	default:
+		return nil, fmt.Errorf("stage %s is not supported by the fake collection", stage.Key)
+	}
+}
+
+// evalExpr evaluates the aggregation expressions used for virtual fields and
+// groups in tests: field paths, literals, documents, $literal and $concat.
+func evalExpr(doc bson.M, expr interface{}) (interface{}, error) {
+	switch e := expr.(type) {
+	case string:
+		if !strings.HasPrefix(e, "$") {
+			return e, nil
+		}
+		var value interface{} = doc
+		for _, key := range strings.Split(e[1:], ".") {
+			switch m := value.(type) {
+			case bson.M:
+				value = m[key]
+			case map[string]interface{}:
+				value = m[key]
+			case bson.D:
+				value = m.Map()[key]
+			default:
+				return nil, nil
+			}
+		}
+		return value, nil
+	case bson.A:
+		values := make(bson.A, len(e))
+		for i, item := range e {
+			v, err := evalExpr(doc, item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	case bson.D, bson.M:
+		d, err := toD(e)
+		if err != nil {
+			return nil, err
+		}
+		if len(d) > 0 && !strings.HasPrefix(d[0].Key, "$") {
+			out := bson.D{}
+			for _, e := range d {
+				value, err := evalExpr(doc, e.Value)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, bson.E{Key: e.Key, Value: value})
+			}
+			return out, nil
+		}
+		if len(d) != 1 {
+			return nil, fmt.Errorf("unsupported expression %v", expr)
+		}
+		switch d[0].Key {
+		case "$literal":
+			return d[0].Value, nil
+		case "$concat":
+			args, err := evalExpr(doc, d[0].Value)
+			if err != nil {
+				return nil, err
+			}
+			list, _ := args.(bson.A)
+			var b strings.Builder
+			for _, arg := range list {
+				str, ok := arg.(string)
+				if !ok {
+					return nil, nil
+				}
+				b.WriteString(str)
+			}
+			return b.String(), nil
+		default:
+			return nil, fmt.Errorf("expression %s is not supported by the fake collection", d[0].Key)
+		}
+	default:
+		return expr, nil
+	}
+}
+
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
+
+// filterExpr converts a compiled Mongo filter back into an expression tree.
+func filterExpr(filter interface{}) (querybuilder.Expr, error) {
+	if filter == nil {
+		return querybuilder.And{}, nil
+	}
+	d, err := toD(filter)
+	if err != nil {
+		return nil, err
+	}
+	and := querybuilder.And{}
+	for _, e := range d {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			list, ok := listValue(e.Value)
+			if !ok {
+				return nil, fmt.Errorf("invalid format for %s", e.Key)
+			}
+			var exprs []querybuilder.Expr
+			for _, item := range list {
+				expr, err := filterExpr(item)
+				if err != nil {
+					return nil, err
+				}
+				exprs = append(exprs, expr)
+			}
+			switch e.Key {
+			case "$and":
+				and.Exprs = append(and.Exprs, querybuilder.And{Exprs: exprs})
+			case "$or":
+				and.Exprs = append(and.Exprs, querybuilder.Or{Exprs: exprs})
+			default:
+				and.Exprs = append(and.Exprs, querybuilder.Not{Expr: querybuilder.Or{Exprs: exprs}})
+			}
+		default:
+			expr, err := fieldExpr(e.Key, e.Value)
+			if err != nil {
+				return nil, err
+			}
+			and.Exprs = append(and.Exprs, expr)
+		}
+	}
+	return and, nil
+}
+
+func fieldExpr(field string, value interface{}) (querybuilder.Expr, error) {
+	ops, ok := operatorDocument(value)
+	if !ok {
+		return querybuilder.Compare{Field: field, Op: querybuilder.Eq, Value: value}, nil
+	}
+	and := querybuilder.And{}
+	for _, op := range ops {
+		switch op.Key {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$size":
+			and.Exprs = append(and.Exprs, querybuilder.Compare{Field: field, Op: querybuilder.Operator(op.Key), Value: op.Value})
+		case "$in", "$nin":
+			list, ok := listValue(op.Value)
+			if !ok {
+				return nil, fmt.Errorf("invalid format for %s", op.Key)
+			}
+			var expr querybuilder.Expr = querybuilder.In{Field: field, Values: list}
+			if op.Key == "$nin" {
+				expr = querybuilder.Not{Expr: expr}
+			}
+			and.Exprs = append(and.Exprs, expr)
+		case "$exists":
+			and.Exprs = append(and.Exprs, querybuilder.Exists{Field: field, Exists: truthy(op.Value)})
+		case "$regex":
+			and.Exprs = append(and.Exprs, querybuilder.Match{Field: field, Pattern: fmt.Sprint(op.Value)})
+		case "$options":
+		case "$not":
+			expr, err := fieldExpr(field, op.Value)
+			if err != nil {
+				return nil, err
+			}
+			and.Exprs = append(and.Exprs, querybuilder.Not{Expr: expr})
+		case "$elemMatch":
+			var (
+				expr querybuilder.Expr
+				err  error
+			)
+			if _, ok := operatorDocument(op.Value); ok {
+				expr, err = fieldExpr("", op.Value)
+			} else {
+				expr, err = filterExpr(op.Value)
+			}
+			if err != nil {
+				return nil, err
+			}
+			and.Exprs = append(and.Exprs, querybuilder.ElemMatch{Field: field, Expr: expr})
+		default:
+			return nil, fmt.Errorf("operator %s is not supported by the fake collection", op.Key)
+		}
+	}
+	return and, nil
+}
+
+// operatorDocument returns value as a document when it is one whose first
+// key is an operator.
+func operatorDocument(value interface{}) (bson.D, bool) {
+	switch value.(type) {
+	case bson.D, bson.M, map[string]interface{}, map[string]int:
+	default:
+		return nil, false
+	}
+	d, err := toD(value)
+	if err != nil || len(d) == 0 || !strings.HasPrefix(d[0].Key, "$") {
+		return nil, false
+	}
+	return d, true
+}
+
+// listValue accepts the arrays of filters built in Go as well as decoded ones.
+func listValue(value interface{}) (bson.A, bool) {
+	switch v := value.(type) {
+	case bson.A:
+		return v, true
+	case []interface{}:
+		return v, true
+	}
+	return nil, false
+}
+
+func toD(value interface{}) (bson.D, error) {
+	if d, ok := value.(bson.D); ok {
+		return d, nil
+	}
+	raw, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var d bson.D
+	if err := bson.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
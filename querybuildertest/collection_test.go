@@ -0,0 +1,204 @@
+package querybuildertest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newTestCollection(t *testing.T) *Collection {
+	t.Helper()
+	c, err := NewCollection(
+		bson.M{"_id": 1, "brand": "acme", "price": 10, "tags": bson.A{"a", "b"}},
+		bson.M{"_id": 2, "brand": "acme", "price": 30, "tags": bson.A{}},
+		bson.M{"_id": 3, "brand": "zeta", "price": 70},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCollectionAggregate(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline mongo.Pipeline
+		want     []bson.M
+		wantErr  bool
+	}{
+		{
+			name: "group",
+			pipeline: mongo.Pipeline{
+				{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: "$brand"},
+					{Key: "total", Value: bson.D{{Key: "$sum", Value: "$price"}}},
+					{Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}},
+					{Key: "max", Value: bson.D{{Key: "$max", Value: "$price"}}},
+				}}},
+				{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+			},
+			want: []bson.M{
+				{"_id": "acme", "total": int64(40), "n": int64(2), "max": int32(30)},
+				{"_id": "zeta", "total": int64(70), "n": int64(1), "max": int32(70)},
+			},
+		},
+		{
+			name: "group by document",
+			pipeline: mongo.Pipeline{
+				{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: bson.D{{Key: "brand", Value: "$brand"}}},
+					{Key: "avg", Value: bson.D{{Key: "$avg", Value: "$price"}}},
+				}}},
+				{{Key: "$limit", Value: 1}},
+			},
+			want: []bson.M{{"_id": bson.M{"brand": "acme"}, "avg": float64(20)}},
+		},
+		{
+			name:     "sort by count",
+			pipeline: mongo.Pipeline{{{Key: "$sortByCount", Value: "$brand"}}},
+			want:     []bson.M{{"_id": "acme", "count": int64(2)}, {"_id": "zeta", "count": int64(1)}},
+		},
+		{
+			name: "bucket",
+			pipeline: mongo.Pipeline{{{Key: "$bucket", Value: bson.D{
+				{Key: "groupBy", Value: "$price"},
+				{Key: "boundaries", Value: []float64{0, 50}},
+				{Key: "default", Value: "other"},
+			}}}},
+			want: []bson.M{{"_id": float64(0), "count": int64(2)}, {"_id": "other", "count": int64(1)}},
+		},
+		{
+			name: "unwind",
+			pipeline: mongo.Pipeline{
+				{{Key: "$unwind", Value: "$tags"}},
+				{{Key: "$project", Value: bson.D{{Key: "tags", Value: 1}}}},
+			},
+			want: []bson.M{{"_id": int32(1), "tags": "a"}, {"_id": int32(1), "tags": "b"}},
+		},
+		{
+			name: "unwind preserving empty arrays",
+			pipeline: mongo.Pipeline{
+				{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$tags"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}},
+				{{Key: "$count", Value: "n"}},
+			},
+			want: []bson.M{{"n": int64(4)}},
+		},
+		{
+			name: "facet",
+			pipeline: mongo.Pipeline{{{Key: "$facet", Value: bson.D{
+				{Key: "brands", Value: bson.A{bson.D{{Key: "$sortByCount", Value: "$brand"}}}},
+				{Key: "total", Value: bson.A{bson.D{{Key: "$count", Value: "n"}}}},
+			}}}},
+			want: []bson.M{{
+				"brands": bson.A{bson.M{"_id": "acme", "count": int64(2)}, bson.M{"_id": "zeta", "count": int64(1)}},
+				"total":  bson.A{bson.M{"n": int64(3)}},
+			}},
+		},
+		{
+			name:     "negative skip",
+			pipeline: mongo.Pipeline{{{Key: "$skip", Value: -1}}},
+			wantErr:  true,
+		},
+		{
+			name:     "zero limit",
+			pipeline: mongo.Pipeline{{{Key: "$limit", Value: 0}}},
+			wantErr:  true,
+		},
+		{
+			name:     "lookup",
+			pipeline: mongo.Pipeline{{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "other"}}}}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := newTestCollection(t).Aggregate(context.Background(), tt.pipeline)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []bson.M
+			if err := cursor.All(context.Background(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectionFindPaging(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *options.FindOptions
+		want    int
+		wantErr bool
+	}{
+		{"skip", options.Find().SetSkip(1), 2, false},
+		{"skip beyond", options.Find().SetSkip(5), 0, false},
+		{"limit", options.Find().SetLimit(2), 2, false},
+		{"negative limit", options.Find().SetLimit(-2), 2, false},
+		{"negative skip", options.Find().SetSkip(-1), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := newTestCollection(t).Find(context.Background(), bson.D{}, tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []bson.M
+			if err := cursor.All(context.Background(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d documents, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalExprPath(t *testing.T) {
+	doc := bson.M{
+		"m": bson.M{"brand": "acme"},
+		"g": map[string]interface{}{"brand": "zeta"},
+		"d": bson.D{{Key: "inner", Value: bson.D{{Key: "brand", Value: "beta"}}}},
+		"s": "flat",
+	}
+	tests := []struct {
+		path string
+		want interface{}
+	}{
+		{"$m.brand", "acme"},
+		{"$g.brand", "zeta"},
+		{"$d.inner.brand", "beta"},
+		{"$s.brand", nil},
+		{"$missing.brand", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := evalExpr(doc, tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
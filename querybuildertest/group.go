@@ -0,0 +1,288 @@
+package querybuildertest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/walkaba/querybuilder"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// group implements $group for the $sum, $avg, $min, $max, $first, $last,
+// $push and $addToSet accumulators. Groups keep the order in which their
+// first document was seen.
+func group(docs []bson.M, spec interface{}) ([]bson.M, error) {
+	fields, err := toD(spec)
+	if err != nil {
+		return nil, err
+	}
+	var idExpr interface{}
+	for _, field := range fields {
+		if field.Key == "_id" {
+			idExpr = field.Value
+		}
+	}
+	var (
+		keys   []string
+		ids    = map[string]interface{}{}
+		groups = map[string][]bson.M{}
+	)
+	for _, doc := range docs {
+		id, err := evalExpr(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		key, err := groupKey(id)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			ids[key] = id
+		}
+		groups[key] = append(groups[key], doc)
+	}
+	result := make([]bson.M, 0, len(keys))
+	for _, key := range keys {
+		out, err := accumulate(groups[key], fields)
+		if err != nil {
+			return nil, err
+		}
+		out["_id"] = ids[key]
+		result = append(result, out)
+	}
+	return result, nil
+}
+
+// bucket implements $bucket. Documents outside the boundaries go to the
+// default bucket, and an error is returned when there is none.
+func bucket(docs []bson.M, spec interface{}) ([]bson.M, error) {
+	d, err := toD(spec)
+	if err != nil {
+		return nil, err
+	}
+	params := d.Map()
+	boundaries, ok := asList(params["boundaries"])
+	if !ok || len(boundaries) < 2 {
+		return nil, errors.New("$bucket needs at least two boundaries")
+	}
+	output := bson.D{{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}
+	if params["output"] != nil {
+		if output, err = toD(params["output"]); err != nil {
+			return nil, err
+		}
+	}
+	def, hasDefault := params["default"]
+	buckets := make([][]bson.M, len(boundaries))
+	for _, doc := range docs {
+		value, err := evalExpr(doc, params["groupBy"])
+		if err != nil {
+			return nil, err
+		}
+		i := bucketIndex(value, boundaries)
+		if i < 0 {
+			if !hasDefault {
+				return nil, fmt.Errorf("$bucket value %v is outside the boundaries", value)
+			}
+			i = len(boundaries) - 1
+		}
+		buckets[i] = append(buckets[i], doc)
+	}
+	var result []bson.M
+	for i, members := range buckets {
+		if len(members) == 0 {
+			continue
+		}
+		out, err := accumulate(members, output)
+		if err != nil {
+			return nil, err
+		}
+		out["_id"] = def
+		if i < len(boundaries)-1 {
+			out["_id"] = boundaries[i]
+		}
+		result = append(result, out)
+	}
+	return result, nil
+}
+
+func bucketIndex(value interface{}, boundaries []interface{}) int {
+	for i := 0; i < len(boundaries)-1; i++ {
+		if compare(value, querybuilder.Gte, boundaries[i]) && compare(value, querybuilder.Lt, boundaries[i+1]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func compare(value interface{}, op querybuilder.Operator, want interface{}) bool {
+	return querybuilder.MatchDocument(querybuilder.Compare{Field: "v", Op: op, Value: want}, bson.M{"v": value})
+}
+
+func accumulate(docs []bson.M, fields bson.D) (bson.M, error) {
+	out := bson.M{}
+	for _, field := range fields {
+		if field.Key == "_id" {
+			continue
+		}
+		spec, err := toD(field.Value)
+		if err != nil || len(spec) != 1 {
+			return nil, fmt.Errorf("invalid accumulator %s", field.Key)
+		}
+		values := make([]interface{}, len(docs))
+		for i, doc := range docs {
+			if values[i], err = evalExpr(doc, spec[0].Value); err != nil {
+				return nil, err
+			}
+		}
+		if out[field.Key], err = accumulator(spec[0].Key, values); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func accumulator(op string, values []interface{}) (interface{}, error) {
+	switch op {
+	case "$sum", "$avg":
+		var (
+			sum   float64
+			n     int
+			float bool
+		)
+		for _, value := range values {
+			if i, ok := toInt(value); ok {
+				sum += float64(i)
+				n++
+				continue
+			}
+			if f, ok := toFloat(value); ok {
+				sum += f
+				n++
+				float = true
+			}
+		}
+		if op == "$avg" {
+			if n == 0 {
+				return nil, nil
+			}
+			return sum / float64(n), nil
+		}
+		if float {
+			return sum, nil
+		}
+		return int64(sum), nil
+	case "$min", "$max":
+		var best interface{}
+		for _, value := range values {
+			if value == nil {
+				continue
+			}
+			if best == nil || (op == "$min" && compare(value, querybuilder.Lt, best)) || (op == "$max" && compare(value, querybuilder.Gt, best)) {
+				best = value
+			}
+		}
+		return best, nil
+	case "$first", "$last":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		if op == "$first" {
+			return values[0], nil
+		}
+		return values[len(values)-1], nil
+	case "$push":
+		return bson.A(values), nil
+	case "$addToSet":
+		set := bson.A{}
+		for _, value := range values {
+			if !containsValue(set, value) {
+				set = append(set, value)
+			}
+		}
+		return set, nil
+	default:
+		return nil, fmt.Errorf("accumulator %s is not supported by the fake collection", op)
+	}
+}
+
+// unwind implements $unwind for top level fields.
+func unwind(docs []bson.M, spec interface{}) ([]bson.M, error) {
+	path, preserve := "", false
+	switch v := spec.(type) {
+	case string:
+		path = v
+	default:
+		d, err := toD(spec)
+		if err != nil {
+			return nil, err
+		}
+		params := d.Map()
+		path, _ = params["path"].(string)
+		preserve = truthy(params["preserveNullAndEmptyArrays"]) && params["preserveNullAndEmptyArrays"] != nil
+	}
+	field := strings.TrimPrefix(path, "$")
+	if field == path || field == "" || strings.Contains(field, ".") {
+		return nil, fmt.Errorf("$unwind of %q is not supported by the fake collection", path)
+	}
+	var result []bson.M
+	for _, doc := range docs {
+		list, ok := asList(doc[field])
+		if !ok {
+			if doc[field] != nil || preserve {
+				result = append(result, doc)
+			}
+			continue
+		}
+		if len(list) == 0 && preserve {
+			out := copyDocument(doc)
+			delete(out, field)
+			result = append(result, out)
+		}
+		for _, item := range list {
+			out := copyDocument(doc)
+			out[field] = item
+			result = append(result, out)
+		}
+	}
+	return result, nil
+}
+
+func asList(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case bson.A:
+		return v, true
+	case []interface{}:
+		return v, true
+	case nil, []byte:
+		return nil, false
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+func copyDocument(doc bson.M) bson.M {
+	out := make(bson.M, len(doc))
+	for key, value := range doc {
+		out[key] = value
+	}
+	return out
+}
+
+// groupKey identifies a group id by its extended JSON form.
+func groupKey(id interface{}) (string, error) {
+	data, err := bson.MarshalExtJSON(bson.M{"id": id}, true, false)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -2,73 +2,99 @@ package querybuilder
 
 import (
 	"context"
-	"errors"
+
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type ReadBuilder struct {
-	collection *mongo.Collection
+	builderConfig
+	collection Collection
 }
 
-func NewSearchBuilder(collection *mongo.Collection) *ReadBuilder {
-	return &ReadBuilder{collection}
+func NewSearchBuilder(collection Collection) *ReadBuilder {
+	return &ReadBuilder{collection: collection}
 }
 
 func (c *ReadBuilder) Find(payload string) (*mongo.Cursor, error) {
-	opt, err := FromQueryString(payload)
+	return c.FindContext(context.TODO(), payload)
+}
+
+func (c *ReadBuilder) FindContext(ctx context.Context, payload string) (*mongo.Cursor, error) {
+	opt, err := c.ParseContext(ctx, payload)
 	if err != nil {
-		return nil, errors.New("invalid query string")
+		return nil, err
 	}
-	queryString := NewQueryBuilder(true)
-	options, err := queryString.FindOptions(opt)
+	return c.FindWithOptions(ctx, opt)
+}
+
+// FindWithOptions runs options returned by Parse or built with New. They are
+// checked against the limits, schema and scope like a parsed query string.
+func (c *ReadBuilder) FindWithOptions(ctx context.Context, opt Options) (*mongo.Cursor, error) {
+	filters, err := c.prepare(ctx, &opt)
 	if err != nil {
 		return nil, err
 	}
-	var filters bson.D
-	if len(opt.Filter) > 0 {
-		filters, err = queryString.Filter(opt)
-		if err != nil {
-			return nil, err
-		}
+	return c.find(ctx, opt, filters)
+}
+
+func (c *ReadBuilder) find(ctx context.Context, opt Options, filters bson.D) (*mongo.Cursor, error) {
+	options, err := c.findOptions(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.beforeExecute(ctx, &filters, options); err != nil {
+		return nil, err
+	}
+	var cursor *mongo.Cursor
+	if !c.needsPipeline(ctx, opt) {
+		cursor, err = c.collection.Find(ctx, filters, options)
 	} else {
-		filters = bson.D{}
+		var pipeline mongo.Pipeline
+		pipeline, err = c.pipeline(ctx, opt, filters, options)
+		if err == nil {
+			cursor, err = c.collection.Aggregate(ctx, pipeline)
+		}
 	}
-	cursor, err := c.collection.Find(context.TODO(), filters, options)
 	if err != nil {
 		if err.Error() != "document is nil" {
 			return nil, err
 		}
 	}
+	if err := c.afterExecute(ctx, cursor); err != nil {
+		return nil, err
+	}
 	return cursor, nil
 }
 
 func (c *ReadBuilder) Search(payload string) (*mongo.SingleResult, error) {
-	opt, err := FromQueryString(payload)
+	return c.SearchContext(context.TODO(), payload)
+}
+
+func (c *ReadBuilder) SearchContext(ctx context.Context, payload string) (*mongo.SingleResult, error) {
+	opt, err := c.ParseContext(ctx, payload)
 	if err != nil {
-		return nil, errors.New("invalid query string")
+		return nil, err
 	}
-	queryString := NewQueryBuilder(true)
-	var filters bson.D
-	if len(opt.Filter) > 0 {
-		filters, err = queryString.Filter(opt)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		filters = bson.D{}
+	return c.SearchWithOptions(ctx, opt)
+}
+
+func (c *ReadBuilder) SearchWithOptions(ctx context.Context, opt Options) (*mongo.SingleResult, error) {
+	filters, err := c.prepare(ctx, &opt)
+	if err != nil {
+		return nil, err
 	}
-	result := c.collection.FindOne(context.TODO(), filters)
-	return result, nil
+	return c.findOne(ctx, c.collection, opt, filters)
 }
 
 func (c *ReadBuilder) FindOne(id string) (*mongo.SingleResult, error) {
-	objectID, err := primitive.ObjectIDFromHex(id)
+	return c.FindOneContext(context.TODO(), id)
+}
+
+func (c *ReadBuilder) FindOneContext(ctx context.Context, id string) (*mongo.SingleResult, error) {
+	filter, err := c.idFilter(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	filter := bson.D{{Key: "_id", Value: objectID}}
-	result := c.collection.FindOne(context.TODO(), filter)
-	return result, nil
+	return c.findOne(ctx, c.collection, Options{}, filter)
 }
@@ -0,0 +1,205 @@
+package querybuilder_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/walkaba/querybuilder"
+	"github.com/walkaba/querybuilder/querybuildertest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// configurable is the builder setup shared by the read builders.
+type configurable interface {
+	SetSchema(schema *querybuilder.Schema)
+	SetScope(scope querybuilder.Scope)
+	AddHook(hook querybuilder.Hook)
+}
+
+// optionsMethod runs opt through one Options accepting method and returns the
+// names of the documents it yields, in order.
+type optionsMethod struct {
+	name     string
+	single   bool
+	newFunc  func(collection querybuilder.Collection) configurable
+	findFunc func(ctx context.Context, b configurable, opt querybuilder.Options) ([]string, error)
+}
+
+var optionsMethods = []optionsMethod{
+	{
+		name:    "ReadBuilder.FindWithOptions",
+		newFunc: func(c querybuilder.Collection) configurable { return querybuilder.NewSearchBuilder(c) },
+		findFunc: func(ctx context.Context, b configurable, opt querybuilder.Options) ([]string, error) {
+			cursor, err := b.(*querybuilder.ReadBuilder).FindWithOptions(ctx, opt)
+			if err != nil {
+				return nil, err
+			}
+			return cursorNames(ctx, cursor)
+		},
+	},
+	{
+		name:    "ReadBuilder.SearchWithOptions",
+		single:  true,
+		newFunc: func(c querybuilder.Collection) configurable { return querybuilder.NewSearchBuilder(c) },
+		findFunc: func(ctx context.Context, b configurable, opt querybuilder.Options) ([]string, error) {
+			result, err := b.(*querybuilder.ReadBuilder).SearchWithOptions(ctx, opt)
+			if err != nil {
+				return nil, err
+			}
+			var doc bson.M
+			if err := result.Decode(&doc); err == mongo.ErrNoDocuments {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			return []string{doc["name"].(string)}, nil
+		},
+	},
+	{
+		name:    "PaginationBuilder.FindWithOptions",
+		newFunc: func(c querybuilder.Collection) configurable { return querybuilder.NewPaginationBuilder(c, "/items") },
+		findFunc: func(ctx context.Context, b configurable, opt querybuilder.Options) ([]string, error) {
+			cursor, err := b.(*querybuilder.PaginationBuilder).FindWithOptions(ctx, opt)
+			if err != nil {
+				return nil, err
+			}
+			return cursorNames(ctx, cursor)
+		},
+	},
+	{
+		name:    "PaginationBuilder.PaginationWithOptions",
+		newFunc: func(c querybuilder.Collection) configurable { return querybuilder.NewPaginationBuilder(c, "/items") },
+		findFunc: func(ctx context.Context, b configurable, opt querybuilder.Options) ([]string, error) {
+			out, err := b.(*querybuilder.PaginationBuilder).PaginationWithOptions(ctx, opt)
+			if err != nil {
+				return nil, err
+			}
+			return cursorNames(ctx, out.Data)
+		},
+	},
+}
+
+func cursorNames(ctx context.Context, cursor *mongo.Cursor) ([]string, error) {
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, doc := range docs {
+		names = append(names, doc["name"].(string))
+	}
+	return names, nil
+}
+
+var itemSchema = &querybuilder.Schema{Fields: map[string]querybuilder.Field{
+	"name":   {Type: "string", Filterable: true, Sortable: true, Selectable: true},
+	"brand":  {Type: "string", Filterable: true, Selectable: true},
+	"price":  {Type: "int", Filterable: true, Sortable: true, Selectable: true},
+	"tenant": {Type: "string", Filterable: true, Selectable: true},
+}}
+
+func TestWithOptionsChanges(t *testing.T) {
+	tenantCtx := context.WithValue(context.Background(), tenantKey{}, "a")
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		setup  func(b configurable)
+		modify func(opt *querybuilder.Options)
+		want   []string
+		many   bool
+	}{
+		{
+			name: "parsed",
+			ctx:  context.Background(),
+			want: []string{"x", "z"},
+		},
+		{
+			name: "default sort",
+			ctx:  context.Background(),
+			modify: func(opt *querybuilder.Options) {
+				if len(opt.Sort) == 0 {
+					opt.Sort = []string{"-price"}
+				}
+			},
+			want: []string{"z", "x"},
+			many: true,
+		},
+		{
+			name: "default page size",
+			ctx:  context.Background(),
+			modify: func(opt *querybuilder.Options) {
+				opt.Sort = []string{"price"}
+				opt.Page["size"] = 1
+			},
+			want: []string{"x"},
+			many: true,
+		},
+		{
+			name: "injected filter",
+			ctx:  context.Background(),
+			modify: func(opt *querybuilder.Options) {
+				opt.Filter["tenant"] = []string{"b"}
+			},
+			want: []string{"z"},
+		},
+		{
+			name:  "scope",
+			ctx:   tenantCtx,
+			setup: func(b configurable) { b.SetScope(querybuilder.ContextScope("tenant", tenantKey{})) },
+			want:  []string{"x"},
+		},
+		{
+			name: "hook",
+			ctx:  context.Background(),
+			setup: func(b configurable) {
+				b.AddHook(querybuilder.Hook{AfterParse: func(ctx context.Context, opt *querybuilder.Options) error {
+					opt.Filter["price"] = []string{">=50"}
+					return nil
+				}})
+			},
+			want: []string{"z"},
+		},
+	}
+	for _, method := range optionsMethods {
+		for _, tt := range tests {
+			if tt.many && method.single {
+				continue
+			}
+			t.Run(method.name+"/"+tt.name, func(t *testing.T) {
+				collection, err := querybuildertest.NewCollection(
+					bson.M{"name": "x", "brand": "acme", "price": 10, "tenant": "a"},
+					bson.M{"name": "y", "brand": "zeta", "price": 20, "tenant": "a"},
+					bson.M{"name": "z", "brand": "acme", "price": 70, "tenant": "b"},
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b := method.newFunc(collection)
+				b.SetSchema(itemSchema)
+				if tt.setup != nil {
+					tt.setup(b)
+				}
+				opt, err := querybuilder.FromQueryString("filter[brand]=acme")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.modify != nil {
+					tt.modify(&opt)
+				}
+				got, err := method.findFunc(tt.ctx, b, opt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := tt.want
+				if method.single {
+					want = want[:1]
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package querybuilder
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ResourceOptions struct {
+	BasePath     string
+	Hooks        []Hook
+	IDCodec      IDCodec
+	JSONAPI      bool
+	Limits       Limits
+	Scope        *Scope
+	VersionField string
+	Validate     func(ctx context.Context, method string, body bson.M) error
+}
+
+// Resource serves list, get, create, update and delete routes for a
+// collection. Mount it under BasePath, for example with http.Handle("/users/", r).
+type Resource struct {
+	opts   ResourceOptions
+	schema *Schema
+	page   *PaginationBuilder
+	read   *ReadBuilder
+	write  *WriteBuilder
+}
+
+func NewResource(collection Collection, schema *Schema, opts ResourceOptions) *Resource {
+	opts.BasePath = strings.TrimSuffix(opts.BasePath, "/")
+	rs := &Resource{
+		opts:   opts,
+		schema: schema,
+		page:   NewPaginationBuilder(collection, opts.BasePath),
+		read:   NewSearchBuilder(collection),
+		write:  NewWriteBuilder(collection),
+	}
+	for _, c := range []*builderConfig{&rs.page.builderConfig, &rs.read.builderConfig, &rs.write.builderConfig} {
+		c.SetIDCodec(opts.IDCodec)
+		c.SetLimits(opts.Limits)
+		c.SetSchema(schema)
+		if opts.Scope != nil {
+			c.SetScope(*opts.Scope)
+		}
+		c.SetExcludeDeleted(true)
+		for _, hook := range opts.Hooks {
+			c.AddHook(hook)
+		}
+	}
+	rs.write.SetVersionField(opts.VersionField)
+	return rs
+}
+
+func (rs *Resource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, rs.opts.BasePath)
+	id := strings.Trim(path, "/")
+	if strings.Contains(id, "/") {
+		rs.problem(w, http.StatusNotFound, nil)
+		return
+	}
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		rs.list(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		rs.create(w, r)
+	case id == "":
+		w.Header().Set("Allow", "GET, POST")
+		rs.problem(w, http.StatusMethodNotAllowed, nil)
+	case r.Method == http.MethodGet:
+		rs.get(w, r, id)
+	case r.Method == http.MethodPatch, r.Method == http.MethodPut:
+		rs.update(w, r, id)
+	case r.Method == http.MethodDelete:
+		rs.delete(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, PATCH, PUT, DELETE")
+		rs.problem(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func (rs *Resource) list(w http.ResponseWriter, r *http.Request) {
+	out, err := rs.page.PaginationContext(r.Context(), r.URL.RawQuery)
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	w.Header().Set("Link", out.Links)
+	w.Header().Set("X-Total-Count", strconv.FormatInt(out.Total, 10))
+	docs := []bson.M{}
+	if out.Data != nil {
+		if err := out.Data.All(r.Context(), &docs); err != nil {
+			rs.writeError(w, err)
+			return
+		}
+	}
+	if !rs.opts.JSONAPI {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": docs,
+			"meta": json.RawMessage(out.Meta),
+		})
+		return
+	}
+	resources := []JSONAPIResource{}
+	var included []JSONAPIResource
+	for _, doc := range docs {
+		resource, related, err := rs.jsonapiResource(doc, out.Options.Fields)
+		if err != nil {
+			rs.writeError(w, err)
+			return
+		}
+		resources = append(resources, resource)
+		included = append(included, related...)
+	}
+	WriteJSONAPI(w, http.StatusOK, JSONAPIDocument{
+		Data:     resources,
+		Meta:     json.RawMessage(out.Meta),
+		Links:    JSONAPILinks(rs.page.Links(out.Options, out.Total)),
+		Included: uniqueResources(included),
+	})
+}
+
+func (rs *Resource) get(w http.ResponseWriter, r *http.Request, id string) {
+	doc, err := rs.find(r.Context(), id)
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	etag := rs.etag(doc)
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rs.writeDocument(w, http.StatusOK, doc)
+}
+
+func (rs *Resource) create(w http.ResponseWriter, r *http.Request) {
+	body, err := rs.decode(r)
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	id, err := rs.write.InsertOneContext(r.Context(), body)
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	doc, err := rs.find(r.Context(), *id)
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", rs.opts.BasePath+"/"+*id)
+	w.Header().Set("ETag", rs.etag(doc))
+	rs.writeDocument(w, http.StatusCreated, doc)
+}
+
+func (rs *Resource) update(w http.ResponseWriter, r *http.Request, id string) {
+	body, err := rs.decode(r)
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	for _, field := range []string{"_id", "createdAt", "updatedAt", "deletedAt", rs.opts.VersionField} {
+		delete(body, field)
+	}
+	update := bson.M{"$set": body}
+	if match := r.Header.Get("If-Match"); match != "" {
+		err = rs.updateIfMatch(r.Context(), id, match, update)
+	} else {
+		_, err = rs.write.UpdateOneContext(r.Context(), id, update)
+	}
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	doc, err := rs.find(r.Context(), id)
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", rs.etag(doc))
+	rs.writeDocument(w, http.StatusOK, doc)
+}
+
+// updateIfMatch applies update only if the If-Match header matches the
+// current document. A single tag is checked atomically against the version
+// field; without one the ETag of the document is compared before updating.
+func (rs *Resource) updateIfMatch(ctx context.Context, id string, header string, update bson.M) error {
+	tags, wildcard, err := parseIfMatch(header)
+	if err != nil {
+		return err
+	}
+	if rs.opts.VersionField != "" && len(tags) == 1 {
+		version, err := ParseETag(tags[0])
+		if err != nil {
+			// not a tag this resource hands out, so it cannot match
+			return fmt.Errorf("%w: %s", ErrVersionConflict, header)
+		}
+		_, err = rs.write.UpdateOneVersionContext(ctx, id, version, update)
+		return err
+	}
+	doc, err := rs.find(ctx, id)
+	if wildcard && errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: no current document", ErrVersionConflict)
+	}
+	if err != nil {
+		return err
+	}
+	if !wildcard && !slices.Contains(tags, rs.etag(doc)) {
+		return fmt.Errorf("%w: %s", ErrVersionConflict, header)
+	}
+	_, err = rs.write.UpdateOneContext(ctx, id, update)
+	return err
+}
+
+// parseIfMatch returns the strong entity tags of an If-Match header, or
+// wildcard for "*". Weak tags are dropped as they never match under the strong
+// comparison If-Match uses (RFC 9110, 13.1.1).
+func parseIfMatch(header string) (tags []string, wildcard bool, err error) {
+	if strings.TrimSpace(header) == "*" {
+		return nil, true, nil
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		opaque := strings.TrimPrefix(tag, "W/")
+		if len(opaque) < 2 || opaque[0] != '"' || opaque[len(opaque)-1] != '"' || strings.Contains(opaque[1:len(opaque)-1], `"`) {
+			return nil, false, fmt.Errorf("%w: %s", ErrInvalidETag, header)
+		}
+		if opaque == tag {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, false, nil
+}
+
+func (rs *Resource) delete(w http.ResponseWriter, r *http.Request, id string) {
+	result, err := rs.write.deleteOne(r.Context(), id)
+	if err == nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rs *Resource) find(ctx context.Context, id string) (bson.M, error) {
+	result, err := rs.read.FindOneContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := result.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (rs *Resource) decode(r *http.Request) (bson.M, error) {
+	body := bson.M{}
+	if rs.opts.JSONAPI {
+		var doc struct {
+			Data JSONAPIResource `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			return nil, &validationError{errors.New("invalid request body")}
+		}
+		if doc.Data.Type != rs.resourceType() {
+			return nil, &validationError{fmt.Errorf("invalid resource type %s", doc.Data.Type)}
+		}
+		for key, value := range doc.Data.Attributes {
+			body[key] = value
+		}
+		if doc.Data.ID != "" && r.Method == http.MethodPost {
+			id, err := rs.parseID(doc.Data.ID)
+			if err != nil {
+				return nil, &validationError{err}
+			}
+			body["_id"] = id
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, &validationError{errors.New("invalid request body")}
+	} else if raw, ok := body["_id"]; ok && r.Method == http.MethodPost {
+		id, err := rs.parseID(raw)
+		if err != nil {
+			return nil, &validationError{err}
+		}
+		body["_id"] = id
+	}
+	if rs.opts.Validate != nil {
+		if err := rs.opts.Validate(r.Context(), r.Method, body); err != nil {
+			return nil, &validationError{err}
+		}
+	}
+	return body, nil
+}
+
+// parseID reads a client supplied _id with the codec, so that the stored key
+// has the type the codec formats.
+func (rs *Resource) parseID(raw interface{}) (interface{}, error) {
+	var id string
+	switch v := raw.(type) {
+	case string:
+		id = v
+	case float64:
+		id = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidID, raw)
+	}
+	key, err := rs.write.codec().Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidID, id)
+	}
+	return key, nil
+}
+
+// etag returns the version tag of doc, or without a version field a hash of
+// its JSON, which changes with every stored change and so is a strong tag.
+func (rs *Resource) etag(doc bson.M) string {
+	if etag := rs.write.ETag(doc); etag != "" {
+		return etag
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return ""
+	}
+	sum := sha1.Sum(raw)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (rs *Resource) writeError(w http.ResponseWriter, err error) {
+	var validationErr *validationError
+	switch {
+	case errors.As(err, &validationErr):
+		rs.problem(w, http.StatusUnprocessableEntity, validationErr.err)
+	case isQueryError(err):
+		rs.problem(w, http.StatusBadRequest, err)
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, ErrInvalidID):
+		rs.problem(w, http.StatusNotFound, nil)
+	case errors.Is(err, ErrInvalidETag):
+		rs.problem(w, http.StatusBadRequest, err)
+	case errors.Is(err, ErrVersionConflict):
+		rs.problem(w, http.StatusPreconditionFailed, err)
+	case errors.Is(err, ErrMissingScope):
+		rs.problem(w, http.StatusForbidden, err)
+	default:
+		rs.problem(w, http.StatusInternalServerError, nil)
+	}
+}
+
+func (rs *Resource) resourceType() string {
+	if rs.schema == nil {
+		return ""
+	}
+	return rs.schema.Type
+}
+
+func (rs *Resource) writeDocument(w http.ResponseWriter, status int, doc bson.M) {
+	if !rs.opts.JSONAPI {
+		writeJSON(w, status, doc)
+		return
+	}
+	resource, _, err := rs.jsonapiResource(doc, nil)
+	if err != nil {
+		rs.writeError(w, err)
+		return
+	}
+	WriteJSONAPI(w, status, JSONAPIDocument{Data: resource})
+}
+
+func (rs *Resource) jsonapiResource(doc bson.M, fields []string) (JSONAPIResource, []JSONAPIResource, error) {
+	if rs.schema == nil {
+		resource, err := NewJSONAPIResource("", doc, rs.read.codec(), fields)
+		return resource, nil, err
+	}
+	return rs.schema.JSONAPIResource(doc, rs.read.codec(), fields)
+}
+
+func (rs *Resource) problem(w http.ResponseWriter, status int, err error) {
+	if rs.opts.JSONAPI {
+		WriteJSONAPI(w, status, NewJSONAPIErrors(status, err))
+		return
+	}
+	WriteProblem(w, status, err)
+}
+
+type validationError struct {
+	err error
+}
+
+func (e *validationError) Error() string {
+	return e.err.Error()
+}
+
+func isQueryError(err error) bool {
+	var (
+		limitErr  *LimitError
+		policyErr *PolicyError
+	)
+	return errors.As(err, &limitErr) || errors.As(err, &policyErr) || errors.Is(err, ErrInvalidQuery)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
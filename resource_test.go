@@ -0,0 +1,159 @@
+package querybuilder_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/walkaba/querybuilder"
+	"github.com/walkaba/querybuilder/querybuildertest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newResource(t *testing.T, opts querybuilder.ResourceOptions, docs ...interface{}) *querybuilder.Resource {
+	t.Helper()
+	collection, err := querybuildertest.NewCollection(docs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &querybuilder.Schema{Fields: map[string]querybuilder.Field{
+		"_id":       {Type: "objectId", Filterable: true, Sortable: true, Selectable: true},
+		"name":      {Type: "string", Filterable: true, Sortable: true, Selectable: true},
+		"deletedAt": {Type: "date", Filterable: true, Selectable: true},
+	}}
+	opts.BasePath = "/users"
+	return querybuilder.NewResource(collection, schema, opts)
+}
+
+func serve(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestResourceStatus(t *testing.T) {
+	live, deleted := primitive.NewObjectID(), primitive.NewObjectID()
+	rs := newResource(t, querybuilder.ResourceOptions{},
+		bson.M{"_id": live, "name": "john"},
+		bson.M{"_id": deleted, "name": "jane", "deletedAt": time.Now()},
+	)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"list", http.MethodGet, "/users", "", http.StatusOK},
+		{"invalid id filter", http.MethodGet, "/users?filter[_id]=nope", "", http.StatusBadRequest},
+		{"invalid id path", http.MethodGet, "/users/nope", "", http.StatusNotFound},
+		{"missing id", http.MethodGet, "/users/5f1a6b9e8f1b2c3d4e5f6a7b", "", http.StatusNotFound},
+		{"get", http.MethodGet, "/users/" + live.Hex(), "", http.StatusOK},
+		{"get deleted", http.MethodGet, "/users/" + deleted.Hex(), "", http.StatusNotFound},
+		{"update deleted", http.MethodPatch, "/users/" + deleted.Hex(), `{"name":"x"}`, http.StatusNotFound},
+		{"delete deleted", http.MethodDelete, "/users/" + deleted.Hex(), "", http.StatusNotFound},
+		{"delete missing", http.MethodDelete, "/users/5f1a6b9e8f1b2c3d4e5f6a7b", "", http.StatusNotFound},
+		{"create with invalid id", http.MethodPost, "/users", `{"_id":"abc","name":"x"}`, http.StatusUnprocessableEntity},
+		{"create with numeric id", http.MethodPost, "/users", `{"_id":1,"name":"x"}`, http.StatusUnprocessableEntity},
+		{"create with id", http.MethodPost, "/users", `{"_id":"5f1a6b9e8f1b2c3d4e5f6a7c","name":"x"}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(rs, tt.method, tt.target, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.target, w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestResourceSoftDelete(t *testing.T) {
+	id := primitive.NewObjectID()
+	rs := newResource(t, querybuilder.ResourceOptions{},
+		bson.M{"_id": id, "name": "john"},
+		bson.M{"name": "jane", "deletedAt": time.Now()},
+	)
+	for _, step := range []struct {
+		method, target string
+		want           int
+		contains       string
+		excludes       string
+	}{
+		{http.MethodGet, "/users", http.StatusOK, "john", "jane"},
+		{http.MethodDelete, "/users/" + id.Hex(), http.StatusNoContent, "", ""},
+		{http.MethodGet, "/users", http.StatusOK, "", "john"},
+		{http.MethodGet, "/users/" + id.Hex(), http.StatusNotFound, "", ""},
+		{http.MethodDelete, "/users/" + id.Hex(), http.StatusNotFound, "", ""},
+	} {
+		w := serve(rs, step.method, step.target, "")
+		if w.Code != step.want {
+			t.Fatalf("%s %s = %d, want %d: %s", step.method, step.target, w.Code, step.want, w.Body)
+		}
+		if step.contains != "" && !strings.Contains(w.Body.String(), step.contains) {
+			t.Errorf("%s %s does not list %s: %s", step.method, step.target, step.contains, w.Body)
+		}
+		if step.excludes != "" && strings.Contains(w.Body.String(), step.excludes) {
+			t.Errorf("%s %s lists %s: %s", step.method, step.target, step.excludes, w.Body)
+		}
+	}
+}
+
+func TestResourceIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		ifMatch func(etag string) string
+		missing bool
+		want    int
+	}{
+		{"current tag", "", func(etag string) string { return etag }, false, http.StatusOK},
+		{"stale tag", "", func(string) string { return `"0123"` }, false, http.StatusPreconditionFailed},
+		{"one of several tags", "", func(etag string) string { return `"0123", ` + etag }, false, http.StatusOK},
+		{"weak tag", "", func(etag string) string { return "W/" + etag }, false, http.StatusPreconditionFailed},
+		{"wildcard", "", func(string) string { return "*" }, false, http.StatusOK},
+		{"wildcard without document", "", func(string) string { return "*" }, true, http.StatusPreconditionFailed},
+		{"malformed", "", func(string) string { return "abc" }, false, http.StatusBadRequest},
+		{"malformed in list", "", func(etag string) string { return etag + ", abc" }, false, http.StatusBadRequest},
+		{"current version", "version", func(etag string) string { return etag }, false, http.StatusOK},
+		{"stale version", "version", func(string) string { return `"1"` }, false, http.StatusPreconditionFailed},
+		{"weak version", "version", func(etag string) string { return "W/" + etag }, false, http.StatusPreconditionFailed},
+		{"foreign tag", "version", func(string) string { return `"abc"` }, false, http.StatusPreconditionFailed},
+		{"version wildcard", "version", func(string) string { return "*" }, false, http.StatusOK},
+		{"version malformed", "version", func(string) string { return `W/"` }, false, http.StatusBadRequest},
+		{"version missing document", "version", func(etag string) string { return etag }, true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := primitive.NewObjectID()
+			rs := newResource(t, querybuilder.ResourceOptions{VersionField: tt.version},
+				bson.M{"_id": id, "name": "john", "version": int64(2)},
+			)
+			etag := serve(rs, http.MethodGet, "/users/"+id.Hex(), "").Header().Get("ETag")
+			if etag == "" {
+				t.Fatal("GET returned no ETag")
+			}
+			target := "/users/" + id.Hex()
+			if tt.missing {
+				target = "/users/" + primitive.NewObjectID().Hex()
+			}
+			r := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(`{"name":"jim"}`))
+			r.Header.Set("If-Match", tt.ifMatch(etag))
+			w := httptest.NewRecorder()
+			rs.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("If-Match %q = %d, want %d: %s", r.Header.Get("If-Match"), w.Code, tt.want, w.Body)
+			}
+			if tt.missing {
+				return
+			}
+			got := serve(rs, http.MethodGet, "/users/"+id.Hex(), "").Body.String()
+			if updated := strings.Contains(got, "jim"); updated != (tt.want == http.StatusOK) {
+				t.Errorf("updated = %v after %d: %s", updated, w.Code, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package querybuilder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ActionFilter  = "filter"
+	ActionSort    = "sort"
+	ActionSelect  = "select"
+	ActionInclude = "include"
+	ActionGroup   = "group"
+)
+
+type Field struct {
+	Type       string
+	Filterable bool
+	Operators  []string
+	Sortable   bool
+	Selectable bool
+	Hidden     bool
+	Unique     bool
+	Expression interface{}
+}
+
+// Schema describes the fields a client may use. Fields absent from the schema
+// are rejected, and Roles override individual field policies for the role
+// returned by Role. Type names the resource in JSON:API documents and sparse
+// fieldsets.
+//
+// Aliases maps API names to stored paths, for example id to _id. Fields are
+// keyed by API name, and filters, sorts and projections are translated
+// before they reach the store. Clients may use the stored paths as well
+// unless RejectStorageNames is set.
+//
+// DefaultSort applies when a query has no sort. Finds are sorted by _id after
+// their sort keys, so that paging is stable when keys tie, unless one of the
+// keys is a Unique field.
+//
+// A field with an Expression is virtual: it is computed with that aggregation
+// expression, and queries that refer to it run as an aggregation with an
+// $addFields stage before $match and $sort.
+type Schema struct {
+	Type               string
+	Fields             map[string]Field
+	Relationships      map[string]Relationship
+	Roles              map[string]map[string]Field
+	Role               func(ctx context.Context) string
+	Aliases            map[string]string
+	RejectStorageNames bool
+	DefaultSort        []string
+}
+
+type PolicyError struct {
+	Field    string
+	Action   string
+	Operator string
+}
+
+func (e *PolicyError) Error() string {
+	if e.Operator != "" {
+		return fmt.Sprintf("operator %s is not allowed on field %s", e.Operator, e.Field)
+	}
+	return fmt.Sprintf("field %s is not allowed in %s", e.Field, e.Action)
+}
+
+func (s *Schema) fields(ctx context.Context) map[string]Field {
+	if s.Role == nil || len(s.Roles) == 0 {
+		return s.Fields
+	}
+	overrides, ok := s.Roles[s.Role(ctx)]
+	if !ok {
+		return s.Fields
+	}
+	fields := make(map[string]Field, len(s.Fields)+len(overrides))
+	for name, field := range s.Fields {
+		fields[name] = field
+	}
+	for name, field := range overrides {
+		fields[name] = field
+	}
+	return fields
+}
+
+func (s *Schema) fieldTypes() map[string]string {
+	types := map[string]string{}
+	for name, field := range s.Fields {
+		types[name] = field.Type
+	}
+	for _, overrides := range s.Roles {
+		for name, field := range overrides {
+			types[name] = field.Type
+		}
+	}
+	if len(s.Aliases) == 0 {
+		return types
+	}
+	stored := make(map[string]string, len(types))
+	for name, fieldType := range types {
+		stored[s.StorageName(name)] = fieldType
+	}
+	for name, fieldType := range stored {
+		types[name] = fieldType
+	}
+	return types
+}
+
+// field looks up the policy of name, resolving stored paths to the API name
+// they are aliased from.
+func (s *Schema) field(fields map[string]Field, name string) (Field, bool) {
+	if field, ok := fields[name]; ok {
+		return field, true
+	}
+	if s.RejectStorageNames {
+		return Field{}, false
+	}
+	if api, ok := s.apiName(name); ok {
+		field, ok := fields[api]
+		return field, ok
+	}
+	return Field{}, false
+}
+
+func (s *Schema) Validate(ctx context.Context, opt Options) error {
+	fields := s.fields(ctx)
+	for _, term := range filterTerms(opt.Filter) {
+		field, ok := s.field(fields, term.Field)
+		if !ok || !field.Filterable {
+			return &PolicyError{Field: term.Field, Action: ActionFilter}
+		}
+		if len(field.Operators) > 0 && !containsOperator(field.Operators, term.Operator) {
+			return &PolicyError{Field: term.Field, Action: ActionFilter, Operator: term.Operator}
+		}
+	}
+	for _, name := range opt.Group {
+		if field, ok := s.field(fields, name); !ok || !field.Selectable || field.Hidden {
+			return &PolicyError{Field: name, Action: ActionGroup}
+		}
+	}
+	for _, facet := range opt.Facets {
+		if field, ok := s.field(fields, facet.Field); !ok || !field.Filterable || field.Hidden {
+			return &PolicyError{Field: facet.Field, Action: ActionGroup}
+		}
+	}
+	for _, expr := range opt.Aggregate {
+		parts := strings.SplitN(expr, ":", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		if field, ok := s.field(fields, parts[1]); !ok || !field.Selectable || field.Hidden {
+			return &PolicyError{Field: parts[1], Action: ActionGroup}
+		}
+	}
+	for _, name := range opt.Sort {
+		if opt.IsAggregation() {
+			break
+		}
+		name = strings.TrimLeft(name, "+-")
+		field, ok := s.field(fields, name)
+		if !ok || !field.Sortable {
+			return &PolicyError{Field: name, Action: ActionSort}
+		}
+	}
+	for _, path := range opt.Include {
+		if err := s.validateInclude(strings.Split(path, ".")); err != nil {
+			return err
+		}
+	}
+	for _, name := range opt.Fields {
+		exclude := strings.HasPrefix(name, "-")
+		name = strings.TrimLeft(name, "+-")
+		if _, ok := s.Relationships[name]; ok {
+			continue
+		}
+		field, ok := s.field(fields, name)
+		if !ok || (!exclude && (!field.Selectable || field.Hidden)) {
+			return &PolicyError{Field: name, Action: ActionSelect}
+		}
+	}
+	return nil
+}
+
+// Projection returns the exclusions needed to keep hidden fields out of the
+// result, or nil when the requested fields already leave them out.
+func (s *Schema) Projection(ctx context.Context, requested []string) map[string]int {
+	for _, name := range requested {
+		if !strings.HasPrefix(name, "-") {
+			return nil
+		}
+	}
+	prj := map[string]int{}
+	for name, field := range s.fields(ctx) {
+		if field.Hidden {
+			prj[s.StorageName(name)] = 0
+		}
+	}
+	if len(prj) == 0 {
+		return nil
+	}
+	for _, name := range requested {
+		prj[s.StorageName(name[1:])] = 0
+	}
+	return prj
+}
+
+// coerceValue converts query string values to the declared field type, other
+// values are returned unchanged.
+func coerceValue(fieldType string, value interface{}) interface{} {
+	str, ok := value.(string)
+	if !ok {
+		return value
+	}
+	switch fieldType {
+	case "int", "int64", "integer":
+		if v, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return v
+		}
+	case "float", "float64", "number", "double":
+		if v, err := strconv.ParseFloat(str, 64); err == nil {
+			return v
+		}
+	case "bool", "boolean":
+		if v, err := strconv.ParseBool(str); err == nil {
+			return v
+		}
+	}
+	return value
+}
+
+func containsOperator(operators []string, operator string) bool {
+	for _, op := range operators {
+		if op == operator {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package querybuilder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	ErrMissingScope = errors.New("missing scope")
+	ErrScopeField   = errors.New("scope field cannot be written")
+)
+
+// Scope restricts every query and write of a builder to the documents whose
+// Field equals the value resolved from the request context.
+type Scope struct {
+	Field   string
+	Resolve func(ctx context.Context) (interface{}, error)
+}
+
+func ContextScope(field string, key interface{}) Scope {
+	return Scope{
+		Field: field,
+		Resolve: func(ctx context.Context) (interface{}, error) {
+			return ctx.Value(key), nil
+		},
+	}
+}
+
+func (s Scope) value(ctx context.Context) (interface{}, error) {
+	if s.Field == "" || s.Resolve == nil {
+		return nil, ErrMissingScope
+	}
+	value, err := s.Resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrMissingScope
+	}
+	if str, ok := value.(string); ok && str == "" {
+		return nil, ErrMissingScope
+	}
+	return value, nil
+}
+
+func (s Scope) filter(ctx context.Context, filters bson.D) (bson.D, error) {
+	value, err := s.value(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scoped := bson.D{{Key: s.Field, Value: value}}
+	if len(filters) == 0 {
+		return scoped, nil
+	}
+	return bson.D{{Key: "$and", Value: bson.A{filters, scoped}}}, nil
+}
+
+func (s Scope) stamp(ctx context.Context, doc bson.M) error {
+	value, err := s.value(ctx)
+	if err != nil {
+		return err
+	}
+	doc[s.Field] = value
+	return nil
+}
+
+// strip returns a copy of update without the scope field in any operator, so
+// a write cannot move a document to another scope. Renaming a field onto the
+// scope field is rejected.
+func (s Scope) strip(update bson.M) (bson.M, error) {
+	result := make(bson.M, len(update))
+	for op, fields := range update {
+		if op == "$rename" {
+			if err := s.checkRename(fields); err != nil {
+				return nil, err
+			}
+		}
+		switch m := fields.(type) {
+		case bson.M:
+			result[op] = s.stripMap(m)
+		case map[string]interface{}:
+			result[op] = s.stripMap(m)
+		case bson.D:
+			d := bson.D{}
+			for _, e := range m {
+				if !s.covers(e.Key) {
+					d = append(d, e)
+				}
+			}
+			result[op] = d
+		default:
+			result[op] = fields
+		}
+	}
+	return result, nil
+}
+
+func (s Scope) stripMap(m map[string]interface{}) bson.M {
+	result := make(bson.M, len(m))
+	for key, value := range m {
+		if !s.covers(key) {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+func (s Scope) checkRename(fields interface{}) error {
+	var targets []interface{}
+	switch m := fields.(type) {
+	case bson.M:
+		for _, target := range m {
+			targets = append(targets, target)
+		}
+	case map[string]interface{}:
+		for _, target := range m {
+			targets = append(targets, target)
+		}
+	case bson.D:
+		for _, e := range m {
+			targets = append(targets, e.Value)
+		}
+	}
+	for _, target := range targets {
+		if name, ok := target.(string); ok && s.covers(name) {
+			return fmt.Errorf("%w: cannot rename a field to %s", ErrScopeField, name)
+		}
+	}
+	return nil
+}
+
+// covers reports whether name is the scope field or a path below it.
+func (s Scope) covers(name string) bool {
+	return name == s.Field || strings.HasPrefix(name, s.Field+".")
+}
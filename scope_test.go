@@ -0,0 +1,87 @@
+package querybuilder
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestScopeStrip(t *testing.T) {
+	scope := Scope{Field: "tenantId"}
+	tests := []struct {
+		name    string
+		update  bson.M
+		want    bson.M
+		wantErr error
+	}{
+		{
+			name:   "bson.M",
+			update: bson.M{"$set": bson.M{"name": "a", "tenantId": "other"}},
+			want:   bson.M{"$set": bson.M{"name": "a"}},
+		},
+		{
+			name:   "map",
+			update: bson.M{"$set": map[string]interface{}{"name": "a", "tenantId": "other"}},
+			want:   bson.M{"$set": bson.M{"name": "a"}},
+		},
+		{
+			name:   "bson.D",
+			update: bson.M{"$set": bson.D{{Key: "tenantId", Value: "other"}, {Key: "name", Value: "a"}}},
+			want:   bson.M{"$set": bson.D{{Key: "name", Value: "a"}}},
+		},
+		{
+			name:   "nested path",
+			update: bson.M{"$set": bson.D{{Key: "tenantId.region", Value: "eu"}, {Key: "tenantIdle", Value: true}}},
+			want:   bson.M{"$set": bson.D{{Key: "tenantIdle", Value: true}}},
+		},
+		{
+			name:   "every operator",
+			update: bson.M{"$unset": bson.M{"tenantId": ""}, "$inc": bson.D{{Key: "tenantId", Value: 1}}},
+			want:   bson.M{"$unset": bson.M{}, "$inc": bson.D{}},
+		},
+		{
+			name:   "rename away",
+			update: bson.M{"$rename": bson.M{"tenantId": "old", "a": "b"}},
+			want:   bson.M{"$rename": bson.M{"a": "b"}},
+		},
+		{
+			name:    "rename onto",
+			update:  bson.M{"$rename": bson.M{"owner": "tenantId"}},
+			wantErr: ErrScopeField,
+		},
+		{
+			name:    "rename onto as bson.D",
+			update:  bson.M{"$rename": bson.D{{Key: "owner", Value: "tenantId.id"}}},
+			wantErr: ErrScopeField,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scope.strip(tt.update)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeStripKeepsUpdate(t *testing.T) {
+	set := bson.M{"name": "a", "tenantId": "other"}
+	if _, err := (Scope{Field: "tenantId"}).strip(bson.M{"$set": set}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := set["tenantId"]; !ok {
+		t.Error("strip modified the caller's $set document")
+	}
+}
@@ -0,0 +1,321 @@
+package querybuilder
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SQLDialect interface {
+	Placeholder(n int) string
+	QuoteIdent(name string) string
+}
+
+type PostgresDialect struct{}
+
+func (PostgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (PostgresDialect) QuoteIdent(name string) string {
+	return quoteIdent(name, `"`)
+}
+
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (SQLiteDialect) QuoteIdent(name string) string {
+	return quoteIdent(name, `"`)
+}
+
+// quoteIdent quotes every dot separated part of name, doubling embedded
+// quote characters so that identifiers can never terminate early.
+func quoteIdent(name string, quote string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// SQLQuery holds parameterized fragments. Where is empty when no filter is
+// given, Args lists the values for its placeholders in order.
+type SQLQuery struct {
+	Columns string
+	Where   string
+	OrderBy string
+	Limit   string
+	Args    []interface{}
+}
+
+type SQLCompiler struct {
+	Dialect SQLDialect
+	Schema  *Schema
+}
+
+// CompileExpr compiles a filter tree into a *SQLQuery holding only the WHERE
+// fragment and its arguments.
+func (c SQLCompiler) CompileExpr(expr Expr) (interface{}, error) {
+	if c.Dialect == nil {
+		return nil, fmt.Errorf("sql dialect is required")
+	}
+	st := &sqlState{dialect: c.Dialect, types: map[string]string{}}
+	if c.Schema != nil {
+		st.types = c.Schema.fieldTypes()
+	}
+	where, err := st.expr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLQuery{Where: where, Args: st.args}, nil
+}
+
+type sqlState struct {
+	dialect SQLDialect
+	types   map[string]string
+	args    []interface{}
+}
+
+func (c SQLCompiler) Compile(ctx context.Context, opt Options) (*SQLQuery, error) {
+	if c.Dialect == nil {
+		return nil, fmt.Errorf("sql dialect is required")
+	}
+	st := &sqlState{dialect: c.Dialect, types: map[string]string{}}
+	if c.Schema != nil {
+		if err := c.Schema.Validate(ctx, opt); err != nil {
+			return nil, err
+		}
+		opt = c.Schema.storageOptions(opt)
+		st.types = c.Schema.fieldTypes()
+	}
+	expr, err := ParseFilter(opt.Filter)
+	if err != nil {
+		return nil, err
+	}
+	q := &SQLQuery{}
+	q.Where, err = st.expr(expr)
+	if err != nil {
+		return nil, err
+	}
+	q.Args = st.args
+	q.Columns, err = c.columns(ctx, opt.Fields)
+	if err != nil {
+		return nil, err
+	}
+	q.OrderBy = c.orderBy(opt.Sort)
+	q.Limit = sqlLimit(opt.Page)
+	return q, nil
+}
+
+// columns lists the selected columns. Excluding columns needs the schema to
+// know the others.
+func (c SQLCompiler) columns(ctx context.Context, fields []string) (string, error) {
+	var include []string
+	exclude := map[string]bool{}
+	for _, field := range fields {
+		if strings.HasPrefix(field, "-") {
+			exclude[field[1:]] = true
+			continue
+		}
+		include = append(include, strings.TrimPrefix(field, "+"))
+	}
+	if len(include) == 0 {
+		if c.Schema == nil && len(exclude) > 0 {
+			return "", fmt.Errorf("%w: excluding fields requires a schema", ErrInvalidQuery)
+		}
+		if c.Schema == nil {
+			return "*", nil
+		}
+		for name, field := range c.Schema.fields(ctx) {
+			name = c.Schema.StorageName(name)
+			if field.Hidden || exclude[name] {
+				continue
+			}
+			include = append(include, name)
+		}
+		sort.Strings(include)
+	}
+	quoted := make([]string, len(include))
+	for i, name := range include {
+		quoted[i] = c.Dialect.QuoteIdent(name)
+	}
+	return strings.Join(quoted, ", "), nil
+}
+
+func (c SQLCompiler) orderBy(fields []string) string {
+	var parts []string
+	for _, field := range fields {
+		dir := "ASC"
+		if strings.HasPrefix(field, "-") {
+			dir = "DESC"
+		}
+		parts = append(parts, c.Dialect.QuoteIdent(strings.TrimLeft(field, "+-"))+" "+dir)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sqlLimit(pagination map[string]int) string {
+	if limit, ok := pagination["limit"]; ok {
+		offset := pagination["offset"]
+		if skip, ok := pagination["skip"]; ok {
+			offset = skip
+		}
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	}
+	if size, ok := pagination["size"]; ok {
+		return fmt.Sprintf("LIMIT %d OFFSET %d", size, pagination["page"]*size)
+	}
+	return ""
+}
+
+func (st *sqlState) expr(expr Expr) (string, error) {
+	switch e := expr.(type) {
+	case And:
+		return st.join(e.Exprs, " AND ")
+	case Or:
+		clause, err := st.join(e.Exprs, " OR ")
+		if err != nil || clause == "" {
+			return clause, err
+		}
+		return "(" + clause + ")", nil
+	case Not:
+		clause, err := st.expr(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + clause + ")", nil
+	case Compare:
+		column := st.dialect.QuoteIdent(e.Field)
+		op, ok := sqlOperators[e.Op]
+		if !ok {
+			return "", fmt.Errorf("operator %s is not supported", e.Op)
+		}
+		if e.Value == nil {
+			switch e.Op {
+			case Eq:
+				return column + " IS NULL", nil
+			case Ne:
+				return column + " IS NOT NULL", nil
+			}
+		}
+		return column + " " + op + " " + st.arg(e.Field, e.Value), nil
+	case In:
+		column := st.dialect.QuoteIdent(e.Field)
+		var (
+			placeholders []string
+			null         bool
+		)
+		for _, value := range e.Values {
+			if value == nil {
+				null = true
+				continue
+			}
+			placeholders = append(placeholders, st.arg(e.Field, value))
+		}
+		clauses := []string{}
+		if len(placeholders) > 0 {
+			clauses = append(clauses, column+" IN ("+strings.Join(placeholders, ", ")+")")
+		}
+		if null {
+			clauses = append(clauses, column+" IS NULL")
+		}
+		if len(clauses) == 0 {
+			return "1 = 0", nil
+		}
+		if len(clauses) == 1 {
+			return clauses[0], nil
+		}
+		return "(" + strings.Join(clauses, " OR ") + ")", nil
+	case Exists:
+		if e.Exists {
+			return st.dialect.QuoteIdent(e.Field) + " IS NOT NULL", nil
+		}
+		return st.dialect.QuoteIdent(e.Field) + " IS NULL", nil
+	case ElemMatch:
+		return st.expr(prefixFields(e.Field, e.Expr))
+	case Match:
+		// Matches ignore case like the $regex the Mongo compiler emits.
+		pattern, err := likePattern(e.Pattern)
+		if err != nil {
+			return "", err
+		}
+		st.args = append(st.args, pattern)
+		return "LOWER(" + st.dialect.QuoteIdent(e.Field) + ") LIKE LOWER(" + st.dialect.Placeholder(len(st.args)) + `) ESCAPE '\'`, nil
+	default:
+		return "", fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+func (st *sqlState) join(exprs []Expr, sep string) (string, error) {
+	var clauses []string
+	for _, child := range exprs {
+		clause, err := st.expr(child)
+		if err != nil {
+			return "", err
+		}
+		if clause == "" {
+			continue
+		}
+		if and, ok := child.(And); ok && sep == " OR " && len(and.Exprs) > 1 {
+			clause = "(" + clause + ")"
+		}
+		clauses = append(clauses, clause)
+	}
+	return strings.Join(clauses, sep), nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// likePattern rewrites a $regex pattern as a LIKE pattern. The ^ and $
+// anchors and escaped characters translate; any other regular expression
+// syntax has no LIKE equivalent and is rejected.
+func likePattern(pattern string) (string, error) {
+	start := strings.HasPrefix(pattern, "^")
+	body := strings.TrimPrefix(pattern, "^")
+	var (
+		b   strings.Builder
+		end bool
+	)
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c == '\\' && i+1 < len(body):
+			i++
+			b.WriteString(likeEscaper.Replace(body[i : i+1]))
+		case c == '$' && i == len(body)-1:
+			end = true
+		case regexMetaRE.MatchString(body[i : i+1]):
+			return "", fmt.Errorf("%w: pattern %q uses regular expression syntax that SQL does not support", ErrInvalidQuery, pattern)
+		default:
+			b.WriteString(likeEscaper.Replace(body[i : i+1]))
+		}
+	}
+	like := b.String()
+	if !start {
+		like = "%" + like
+	}
+	if !end {
+		like += "%"
+	}
+	return like, nil
+}
+
+var sqlOperators = map[Operator]string{
+	Eq:  "=",
+	Ne:  "<>",
+	Gt:  ">",
+	Gte: ">=",
+	Lt:  "<",
+	Lte: "<=",
+}
+
+// arg appends value, converted to the schema type of field, and returns its
+// placeholder.
+func (st *sqlState) arg(field string, value interface{}) string {
+	st.args = append(st.args, coerceValue(st.types[field], value))
+	return st.dialect.Placeholder(len(st.args))
+}
@@ -0,0 +1,186 @@
+package querybuilder
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSQLCompile(t *testing.T) {
+	schema := &Schema{Fields: map[string]Field{
+		"name":   {Type: "string", Filterable: true, Selectable: true},
+		"email":  {Type: "string", Selectable: true},
+		"secret": {Type: "string", Hidden: true},
+	}}
+	tests := []struct {
+		name     string
+		schema   *Schema
+		dialect  SQLDialect
+		opt      Options
+		query    string
+		wantCols string
+		where    string
+		orderBy  string
+		limit    string
+		args     []interface{}
+		wantErr  error
+	}{
+		{
+			name:     "postgres match",
+			dialect:  PostgresDialect{},
+			opt:      Options{Filter: map[string]interface{}{"name][$like": "Jo_n"}},
+			wantCols: "*",
+			where:    `LOWER("name") LIKE LOWER($1) ESCAPE '\'`,
+			args:     []interface{}{`%Jo\_n%`},
+		},
+		{
+			name:     "sqlite match",
+			dialect:  SQLiteDialect{},
+			opt:      Options{Filter: map[string]interface{}{"name][$like": "jo"}},
+			wantCols: "*",
+			where:    `LOWER("name") LIKE LOWER(?) ESCAPE '\'`,
+			args:     []interface{}{"%jo%"},
+		},
+		{
+			name:     "regex anchored at the start",
+			dialect:  PostgresDialect{},
+			query:    "filter[name][$regex]=^jo",
+			wantCols: "*",
+			where:    `LOWER("name") LIKE LOWER($1) ESCAPE '\'`,
+			args:     []interface{}{"jo%"},
+		},
+		{
+			name:     "regex anchored at the end",
+			dialect:  PostgresDialect{},
+			query:    "filter[name][$regex]=jo$",
+			wantCols: "*",
+			where:    `LOWER("name") LIKE LOWER($1) ESCAPE '\'`,
+			args:     []interface{}{"%jo"},
+		},
+		{
+			name:     "regex with escapes",
+			dialect:  PostgresDialect{},
+			opt:      Options{Filter: map[string]interface{}{"name][$regex": `^50\%\.\$$`}},
+			wantCols: "*",
+			where:    `LOWER("name") LIKE LOWER($1) ESCAPE '\'`,
+			args:     []interface{}{`50\%.$`},
+		},
+		{
+			name:    "regex metacharacters",
+			dialect: PostgresDialect{},
+			query:   "filter[name][$regex]=j.n",
+			wantErr: ErrInvalidQuery,
+		},
+		{
+			name:     "in",
+			dialect:  PostgresDialect{},
+			query:    "filter[status]=a,b",
+			wantCols: "*",
+			where:    `"status" IN ($1, $2)`,
+			args:     []interface{}{"a", "b"},
+		},
+		{
+			name:     "in with null",
+			dialect:  PostgresDialect{},
+			query:    "filter[status]=a,null",
+			wantCols: "*",
+			where:    `("status" IN ($1) OR "status" IS NULL)`,
+			args:     []interface{}{"a"},
+		},
+		{
+			name:     "is null",
+			dialect:  PostgresDialect{},
+			query:    "filter[status]=null",
+			wantCols: "*",
+			where:    `"status" IS NULL`,
+		},
+		{
+			name:     "or groups",
+			dialect:  SQLiteDialect{},
+			query:    "filter[$or][0][name]=a&filter[$or][1][age][$lt]=5&filter[$or][1][name]=b",
+			wantCols: "*",
+			where:    `("name" = ? OR ("age" < ? AND "name" = ?))`,
+			args:     []interface{}{"a", 5, "b"},
+		},
+		{
+			name:     "order by and limit",
+			dialect:  PostgresDialect{},
+			query:    "sort=-age,name&page[limit]=10&page[offset]=20",
+			wantCols: "*",
+			orderBy:  `"age" DESC, "name" ASC`,
+			limit:    "LIMIT 10 OFFSET 20",
+		},
+		{
+			name:     "page size",
+			dialect:  PostgresDialect{},
+			query:    "page[size]=10&page[page]=2",
+			wantCols: "*",
+			limit:    "LIMIT 10 OFFSET 20",
+		},
+		{
+			name:     "quoted identifiers",
+			dialect:  PostgresDialect{},
+			query:    `fields=user.name&filter[a"b.c]=x&sort=a"b`,
+			wantCols: `"user"."name"`,
+			where:    `"a""b"."c" = $1`,
+			orderBy:  `"a""b" ASC`,
+			args:     []interface{}{"x"},
+		},
+		{
+			name:     "included fields",
+			dialect:  PostgresDialect{},
+			opt:      Options{Fields: []string{"name"}},
+			wantCols: `"name"`,
+		},
+		{
+			name:     "excluded fields",
+			schema:   schema,
+			dialect:  PostgresDialect{},
+			opt:      Options{Fields: []string{"-email"}},
+			wantCols: `"name"`,
+		},
+		{
+			name:    "excluded fields without schema",
+			dialect: PostgresDialect{},
+			opt:     Options{Fields: []string{"-email"}},
+			wantErr: ErrInvalidQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.query != "" {
+				opt, err := FromQueryString(tt.query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.opt = opt
+			}
+			q, err := SQLCompiler{Dialect: tt.dialect, Schema: tt.schema}.Compile(context.Background(), tt.opt)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Columns != tt.wantCols {
+				t.Errorf("columns = %s, want %s", q.Columns, tt.wantCols)
+			}
+			if q.Where != tt.where {
+				t.Errorf("where = %s, want %s", q.Where, tt.where)
+			}
+			if q.OrderBy != tt.orderBy {
+				t.Errorf("order by = %s, want %s", q.OrderBy, tt.orderBy)
+			}
+			if q.Limit != tt.limit {
+				t.Errorf("limit = %s, want %s", q.Limit, tt.limit)
+			}
+			if !reflect.DeepEqual(q.Args, tt.args) {
+				t.Errorf("args = %v, want %v", q.Args, tt.args)
+			}
+		})
+	}
+}
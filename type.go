@@ -9,11 +9,16 @@ type Page struct {
 }
 
 type Meta struct {
-	Page    Page        `json:"page"`
-	Filters interface{} `json:"filters"`
+	Page    Page                    `json:"page"`
+	Filters interface{}             `json:"filters"`
+	Facets  map[string][]FacetCount `json:"facets,omitempty"`
 }
 
 type OutPagination struct {
-	Data *mongo.Cursor `json:"data"`
-	Meta []byte        `json:"meta"`
+	Data  *mongo.Cursor `json:"data"`
+	Meta  []byte        `json:"meta"`
+	Links string        `json:"links"`
+	Total int64         `json:"total"`
+
+	Options Options `json:"-"`
 }
@@ -0,0 +1,114 @@
+package querybuilder
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// virtualFields returns the sorted names of the virtual fields opt refers to
+// in its filter, sort, fields, group, facets or aggregates.
+func (c *builderConfig) virtualFields(ctx context.Context, opt Options) []string {
+	if c.schema == nil {
+		return nil
+	}
+	fields := c.schema.fields(ctx)
+	seen := map[string]bool{}
+	add := func(name string) {
+		name = strings.TrimLeft(name, "+-")
+		if field, ok := fields[name]; ok && field.Expression != nil {
+			seen[name] = true
+		}
+	}
+	for _, term := range filterTerms(opt.Filter) {
+		add(term.Field)
+	}
+	sortKeys := opt.Sort
+	if !opt.IsAggregation() {
+		sortKeys = c.sortKeys(ctx, opt)
+	}
+	for _, names := range [][]string{sortKeys, opt.Fields, opt.Group} {
+		for _, name := range names {
+			add(name)
+		}
+	}
+	for _, facet := range opt.Facets {
+		add(facet.Field)
+	}
+	for _, expr := range opt.Aggregate {
+		if parts := strings.SplitN(expr, ":", 2); len(parts) == 2 {
+			add(parts[1])
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// virtualStages computes the virtual fields opt refers to with an $addFields
+// stage, so that the $match and $sort stages after it can use them. It
+// returns nil when none are referenced and a plain find can run.
+func (c *builderConfig) virtualStages(ctx context.Context, opt Options) []bson.D {
+	names := c.virtualFields(ctx, opt)
+	if len(names) == 0 {
+		return nil
+	}
+	fields := c.schema.fields(ctx)
+	add := bson.D{}
+	for _, name := range names {
+		add = append(add, bson.E{Key: name, Value: fields[name].Expression})
+	}
+	return []bson.D{{{Key: "$addFields", Value: add}}}
+}
+
+// virtualUnset removes the virtual fields that were computed only to filter
+// or sort, so results look the same as those of a find.
+func (c *builderConfig) virtualUnset(ctx context.Context, opt Options) []bson.D {
+	selected := map[string]bool{}
+	for _, name := range opt.Fields {
+		if !strings.HasPrefix(name, "-") {
+			selected[strings.TrimPrefix(name, "+")] = true
+		}
+	}
+	var unset bson.A
+	for _, name := range c.virtualFields(ctx, opt) {
+		if !selected[name] {
+			unset = append(unset, name)
+		}
+	}
+	if len(unset) == 0 {
+		return nil
+	}
+	return []bson.D{{{Key: "$unset", Value: unset}}}
+}
+
+// count counts the documents matching filters, through an aggregation when
+// the filter refers to virtual fields.
+func (c *builderConfig) count(ctx context.Context, collection Collection, opt Options, filters bson.D) (int64, error) {
+	stages := c.virtualStages(ctx, opt)
+	if stages == nil {
+		return collection.CountDocuments(ctx, filters)
+	}
+	pipeline := append(mongo.Pipeline{}, stages...)
+	pipeline = append(pipeline, bson.D{{Key: "$match", Value: filters}}, bson.D{{Key: "$count", Value: "total"}})
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var counts []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return 0, err
+	}
+	if len(counts) == 0 {
+		return 0, nil
+	}
+	return counts[0].Total, nil
+}
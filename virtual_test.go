@@ -0,0 +1,54 @@
+package querybuilder_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/walkaba/querybuilder"
+	"github.com/walkaba/querybuilder/querybuildertest"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSearchVirtualFields(t *testing.T) {
+	collection, err := querybuildertest.NewCollection(
+		bson.M{"_id": 1, "name": bson.M{"first": "John", "last": "Smith"}, "age": 40, "city": "Lisbon"},
+		bson.M{"_id": 2, "name": bson.M{"first": "Jane", "last": "Doe"}, "age": 30, "city": "Porto"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := querybuilder.NewSearchBuilder(collection)
+	rb.SetSchema(&querybuilder.Schema{Fields: map[string]querybuilder.Field{
+		"_id":  {Type: "int", Filterable: true, Selectable: true},
+		"age":  {Type: "int", Filterable: true, Selectable: true},
+		"city": {Type: "string", Filterable: true, Selectable: true},
+		"fullName": {Type: "string", Filterable: true, Selectable: true, Expression: bson.D{
+			{Key: "$concat", Value: bson.A{"$name.first", " ", "$name.last"}},
+		}},
+	}})
+	tests := []struct {
+		name  string
+		query string
+		want  bson.M
+	}{
+		{"selected", "filter[city]=Porto&fields=fullName", bson.M{"_id": int32(2), "fullName": "Jane Doe"}},
+		{"filtered and selected", "filter[fullName]=John Smith&fields=fullName,age", bson.M{"_id": int32(1), "fullName": "John Smith", "age": int32(40)}},
+		{"filtered only", "filter[fullName]=John Smith&fields=age", bson.M{"_id": int32(1), "age": int32(40)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := rb.SearchContext(context.Background(), tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bson.M
+			if err := result.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,10 @@ package querybuilder
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -9,42 +13,249 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+var (
+	ErrVersionConflict = errors.New("version conflict")
+	ErrInvalidETag     = errors.New("invalid etag")
+)
+
 type WriteBuilder struct {
-	collection *mongo.Collection
+	builderConfig
+	collection   Collection
+	versionField string
+}
+
+func NewWriteBuilder(collection Collection) *WriteBuilder {
+	return &WriteBuilder{collection: collection}
+}
+
+// SetVersionField enables optimistic locking on the given field. A counter
+// field is incremented on every update; "updatedAt" uses the timestamp itself.
+func (c *WriteBuilder) SetVersionField(field string) {
+	c.versionField = field
 }
 
-func NewWriteBuilder(collection *mongo.Collection) *WriteBuilder {
-	return &WriteBuilder{collection}
+func (c *WriteBuilder) VersionField() string {
+	return c.versionField
 }
 
 func (c *WriteBuilder) DeleteOne(id string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
+	return c.DeleteOneContext(context.TODO(), id)
+}
+
+func (c *WriteBuilder) DeleteOneContext(ctx context.Context, id string) error {
+	_, err := c.deleteOne(ctx, id)
+	return err
+}
+
+func (c *WriteBuilder) deleteOne(ctx context.Context, id string) (*mongo.UpdateResult, error) {
+	filter, err := c.filter(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	filter := bson.D{{Key: "_id", Value: objectID}}
-	_, err = c.collection.UpdateOne(context.TODO(), filter, bson.D{{Key: "$set", Value: bson.D{{Key: "deletedAt", Value: time.Now()}}}})
-	return err
+	return c.collection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "deletedAt", Value: time.Now()}}}})
+}
+
+// filter returns the scoped filter of id after the BeforeExecute hooks.
+func (c *WriteBuilder) filter(ctx context.Context, id string) (bson.D, error) {
+	filter, err := c.idFilter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.beforeExecute(ctx, &filter, nil); err != nil {
+		return nil, err
+	}
+	return filter, nil
 }
 
 func (c *WriteBuilder) UpdateOne(id string, update bson.M) (*string, error) {
-	objectID, err := primitive.ObjectIDFromHex(id)
+	return c.UpdateOneContext(context.TODO(), id, update)
+}
+
+func (c *WriteBuilder) UpdateOneContext(ctx context.Context, id string, update bson.M) (*string, error) {
+	filter, err := c.filter(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	filter := bson.D{{Key: "_id", Value: objectID}}
-	now := time.Now()
-	if setFields, ok := update["$set"].(bson.M); ok {
-		setFields["updatedAt"] = now
+	update, err = c.stampUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// UpdateOneVersion applies the update only if the stored version still equals
+// version, returning ErrVersionConflict otherwise.
+func (c *WriteBuilder) UpdateOneVersion(id string, version int64, update bson.M) (*string, error) {
+	return c.UpdateOneVersionContext(context.TODO(), id, version, update)
+}
+
+func (c *WriteBuilder) UpdateOneVersionContext(ctx context.Context, id string, version int64, update bson.M) (*string, error) {
+	if c.versionField == "" {
+		return nil, errors.New("version field is not configured")
 	}
-	_, err = c.collection.UpdateOne(context.TODO(), filter, update)
+	idFilter, err := c.filter(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	filter := bson.D{{Key: "$and", Value: bson.A{idFilter, bson.D{{Key: c.versionField, Value: c.versionValue(version)}}}}}
+	update, err = c.stampUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		count, err := c.collection.CountDocuments(ctx, idFilter)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+		return nil, ErrVersionConflict
+	}
 	return &id, nil
 }
 
+func (c *WriteBuilder) UpdateOneIfMatch(id string, ifMatch string, update bson.M) (*string, error) {
+	return c.UpdateOneIfMatchContext(context.TODO(), id, ifMatch, update)
+}
+
+func (c *WriteBuilder) UpdateOneIfMatchContext(ctx context.Context, id string, ifMatch string, update bson.M) (*string, error) {
+	version, err := ParseETag(ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	return c.UpdateOneVersionContext(ctx, id, version, update)
+}
+
+func (c *WriteBuilder) versionValue(version int64) interface{} {
+	if c.versionField == "updatedAt" {
+		return time.UnixMilli(version)
+	}
+	return version
+}
+
+// ETag returns the entity tag of a stored document, or an empty string when
+// versioning is disabled or the document carries no version.
+func (c *WriteBuilder) ETag(doc bson.M) string {
+	if c.versionField == "" {
+		return ""
+	}
+	switch v := doc[c.versionField].(type) {
+	case int32:
+		return formatETag(int64(v))
+	case int64:
+		return formatETag(v)
+	case int:
+		return formatETag(int64(v))
+	case primitive.DateTime:
+		return formatETag(int64(v))
+	case time.Time:
+		return formatETag(v.UnixMilli())
+	default:
+		return ""
+	}
+}
+
+func formatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ParseETag returns the version of an entity tag written by ETag. Anything
+// else is an error matching ErrInvalidETag.
+func ParseETag(etag string) (int64, error) {
+	tag := strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	tag = strings.Trim(tag, `"`)
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidETag, etag)
+	}
+	return version, nil
+}
+
+// stampUpdate returns a copy of update with updatedAt set and, for a counter
+// version field, the version incremented. $set and $inc may be any document
+// type; the caller's documents are copied, never modified.
+func (c *WriteBuilder) stampUpdate(update bson.M) (bson.M, error) {
+	stamped := make(bson.M, len(update)+1)
+	for op, fields := range update {
+		stamped[op] = fields
+	}
+	set, err := updateFields(update, "$set")
+	if err != nil {
+		return nil, err
+	}
+	if set != nil || c.versionField != "" {
+		set = withUpdateField(set, "updatedAt", time.Now())
+	}
+	if c.versionField != "" && c.versionField != "updatedAt" {
+		set = withoutUpdateField(set, c.versionField)
+		inc, err := updateFields(update, "$inc")
+		if err != nil {
+			return nil, err
+		}
+		stamped["$inc"] = withUpdateField(inc, c.versionField, int64(1))
+	}
+	if set != nil {
+		stamped["$set"] = set
+	}
+	if c.scope != nil {
+		return c.scope.strip(stamped)
+	}
+	return stamped, nil
+}
+
+// updateFields copies the fields of an update operator. It returns nil when
+// the operator is absent and an error when it is not a document.
+func updateFields(update bson.M, op string) (bson.D, error) {
+	value, ok := update[op]
+	if !ok {
+		return nil, nil
+	}
+	var m map[string]interface{}
+	switch v := value.(type) {
+	case bson.D:
+		return append(bson.D{}, v...), nil
+	case bson.M:
+		m = v
+	case map[string]interface{}:
+		m = v
+	default:
+		return nil, fmt.Errorf("%s must be a document, got %T", op, value)
+	}
+	fields := bson.D{}
+	for _, key := range sortedKeys(m) {
+		fields = append(fields, bson.E{Key: key, Value: m[key]})
+	}
+	return fields, nil
+}
+
+func withUpdateField(fields bson.D, key string, value interface{}) bson.D {
+	return append(withoutUpdateField(fields, key), bson.E{Key: key, Value: value})
+}
+
+func withoutUpdateField(fields bson.D, key string) bson.D {
+	result := bson.D{}
+	for _, e := range fields {
+		if e.Key != key {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
 func (c *WriteBuilder) InsertOne(body interface{}) (*string, error) {
+	return c.InsertOneContext(context.TODO(), body)
+}
+
+func (c *WriteBuilder) InsertOneContext(ctx context.Context, body interface{}) (*string, error) {
 	now := time.Now()
 	bodyMap := bson.M{}
 	bodyBytes, err := bson.Marshal(body)
@@ -57,10 +268,28 @@ func (c *WriteBuilder) InsertOne(body interface{}) (*string, error) {
 	}
 	bodyMap["createdAt"] = now
 	bodyMap["updatedAt"] = now
-	result, err := c.collection.InsertOne(context.TODO(), bodyMap)
+	if c.scope != nil {
+		if err := c.scope.stamp(ctx, bodyMap); err != nil {
+			return nil, err
+		}
+	}
+	if c.versionField != "" && c.versionField != "updatedAt" {
+		bodyMap[c.versionField] = int64(1)
+	}
+	if _, ok := bodyMap["_id"]; !ok {
+		generated := c.codec().Generate()
+		if generated == nil {
+			return nil, errors.New("missing _id")
+		}
+		bodyMap["_id"] = generated
+	}
+	result, err := c.collection.InsertOne(ctx, bodyMap)
+	if err != nil {
+		return nil, err
+	}
+	id, err := c.codec().Format(result.InsertedID)
 	if err != nil {
 		return nil, err
 	}
-	id := result.InsertedID.(primitive.ObjectID).Hex()
 	return &id, nil
 }
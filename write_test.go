@@ -0,0 +1,281 @@
+package querybuilder_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/walkaba/querybuilder"
+	"github.com/walkaba/querybuilder/querybuildertest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type tenantKey struct{}
+
+func TestWriteBuilderUpdateOne(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  bson.M
+		version string
+		scoped  bool
+		want    bson.M
+		wantErr bool
+	}{
+		{
+			name:   "bson.M",
+			update: bson.M{"$set": bson.M{"name": "b"}},
+			want:   bson.M{"name": "b", "version": int64(1), "tenantId": "t1"},
+		},
+		{
+			name:   "map",
+			update: bson.M{"$set": map[string]interface{}{"name": "b"}},
+			want:   bson.M{"name": "b", "version": int64(1), "tenantId": "t1"},
+		},
+		{
+			name:   "bson.D",
+			update: bson.M{"$set": bson.D{{Key: "name", Value: "b"}}},
+			want:   bson.M{"name": "b", "version": int64(1), "tenantId": "t1"},
+		},
+		{
+			name:    "version with bson.D",
+			update:  bson.M{"$set": bson.D{{Key: "name", Value: "b"}, {Key: "version", Value: int64(9)}}, "$inc": bson.D{{Key: "count", Value: 2}}},
+			version: "version",
+			want:    bson.M{"name": "b", "version": int64(2), "count": int64(2), "tenantId": "t1"},
+		},
+		{
+			name:    "version with map $inc",
+			update:  bson.M{"$inc": map[string]interface{}{"count": 2}},
+			version: "version",
+			want:    bson.M{"name": "a", "version": int64(2), "count": int64(2), "tenantId": "t1"},
+		},
+		{
+			name:   "scope with bson.D",
+			update: bson.M{"$set": bson.D{{Key: "name", Value: "b"}, {Key: "tenantId", Value: "t2"}}},
+			scoped: true,
+			want:   bson.M{"name": "b", "version": int64(1), "tenantId": "t1"},
+		},
+		{
+			name:    "not a document",
+			update:  bson.M{"$set": "name"},
+			wantErr: true,
+		},
+		{
+			name:    "struct",
+			update:  bson.M{"$inc": struct{ Count int }{1}},
+			version: "version",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := primitive.NewObjectID()
+			collection, err := querybuildertest.NewCollection(bson.M{"_id": id, "name": "a", "version": int64(1), "tenantId": "t1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.WithValue(context.Background(), tenantKey{}, "t1")
+			wb := querybuilder.NewWriteBuilder(collection)
+			wb.SetVersionField(tt.version)
+			if tt.scoped {
+				wb.SetScope(querybuilder.ContextScope("tenantId", tenantKey{}))
+			}
+			before := copyUpdate(tt.update)
+			_, err = wb.UpdateOneContext(ctx, id.Hex(), tt.update)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.update, before) {
+				t.Errorf("the update was modified: %v, was %v", tt.update, before)
+			}
+			doc := collection.Documents()[0]
+			if _, ok := doc["updatedAt"]; !ok {
+				t.Error("updatedAt was not set")
+			}
+			for key, want := range tt.want {
+				if !reflect.DeepEqual(doc[key], want) {
+					t.Errorf("%s = %#v, want %#v", key, doc[key], want)
+				}
+			}
+		})
+	}
+}
+
+func copyUpdate(update bson.M) bson.M {
+	result := bson.M{}
+	for op, fields := range update {
+		switch v := fields.(type) {
+		case bson.M:
+			c := bson.M{}
+			for k, val := range v {
+				c[k] = val
+			}
+			result[op] = c
+		case map[string]interface{}:
+			c := map[string]interface{}{}
+			for k, val := range v {
+				c[k] = val
+			}
+			result[op] = c
+		case bson.D:
+			result[op] = append(bson.D{}, v...)
+		default:
+			result[op] = v
+		}
+	}
+	return result
+}
+
+func TestStringCodecRoundTrip(t *testing.T) {
+	collection, err := querybuildertest.NewCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb := querybuilder.NewWriteBuilder(collection)
+	wb.SetIDCodec(querybuilder.StringCodec{})
+	rb := querybuilder.NewSearchBuilder(collection)
+	rb.SetIDCodec(querybuilder.StringCodec{})
+
+	id, err := wb.InsertOne(bson.M{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wb.UpdateOne(*id, bson.M{"$set": bson.M{"name": "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := rb.FindOne(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bson.M
+	if err := result.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["_id"] != *id || got["name"] != "b" {
+		t.Errorf("got %v, want the updated document %s", got, *id)
+	}
+}
+
+func TestParseETag(t *testing.T) {
+	tests := []struct {
+		etag    string
+		want    int64
+		wantErr bool
+	}{
+		{etag: `"3"`, want: 3},
+		{etag: ` "42" `, want: 42},
+		{etag: `W/"7"`, want: 7},
+		{etag: `*`, wantErr: true},
+		{etag: `"abc"`, wantErr: true},
+		{etag: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.etag, func(t *testing.T) {
+			got, err := querybuilder.ParseETag(tt.etag)
+			if tt.wantErr {
+				if !errors.Is(err, querybuilder.ErrInvalidETag) {
+					t.Fatalf("got %v, want ErrInvalidETag", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteBuilderUpdateOneVersion(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		field   string
+		id      string
+		version func(doc bson.M, wb *querybuilder.WriteBuilder) int64
+		wantErr error
+	}{
+		{
+			name:  "matching version",
+			field: "version",
+			version: func(bson.M, *querybuilder.WriteBuilder) int64 {
+				return 3
+			},
+		},
+		{
+			name:  "conflict",
+			field: "version",
+			version: func(bson.M, *querybuilder.WriteBuilder) int64 {
+				return 2
+			},
+			wantErr: querybuilder.ErrVersionConflict,
+		},
+		{
+			name:  "missing document",
+			field: "version",
+			id:    primitive.NewObjectID().Hex(),
+			version: func(bson.M, *querybuilder.WriteBuilder) int64 {
+				return 3
+			},
+			wantErr: mongo.ErrNoDocuments,
+		},
+		{
+			name:  "updatedAt",
+			field: "updatedAt",
+			version: func(doc bson.M, wb *querybuilder.WriteBuilder) int64 {
+				version, err := querybuilder.ParseETag(wb.ETag(doc))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return version
+			},
+		},
+		{
+			name:  "stale updatedAt",
+			field: "updatedAt",
+			version: func(bson.M, *querybuilder.WriteBuilder) int64 {
+				return updatedAt.Add(-time.Second).UnixMilli()
+			},
+			wantErr: querybuilder.ErrVersionConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oid := primitive.NewObjectID()
+			collection, err := querybuildertest.NewCollection(bson.M{"_id": oid, "name": "a", "version": int64(3), "updatedAt": updatedAt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wb := querybuilder.NewWriteBuilder(collection)
+			wb.SetVersionField(tt.field)
+			id := tt.id
+			if id == "" {
+				id = oid.Hex()
+			}
+			version := tt.version(collection.Documents()[0], wb)
+			_, err = wb.UpdateOneVersion(id, version, bson.M{"$set": bson.M{"name": "b"}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			doc := collection.Documents()[0]
+			wantName := "b"
+			if tt.wantErr != nil {
+				wantName = "a"
+			}
+			if doc["name"] != wantName {
+				t.Errorf("name = %v, want %v", doc["name"], wantName)
+			}
+			if tt.wantErr == nil && tt.field == "version" && doc["version"] != int64(4) {
+				t.Errorf("version = %v, want 4", doc["version"])
+			}
+		})
+	}
+}